@@ -11,6 +11,9 @@ http POST http://localhost:8080/api/v1/reservations < invalid-payment.json
 echo "Place a room reservation with unavailable room"
 http POST http://localhost:8080/api/v1/reservations < invalid-room-taken.json
 
+echo "Place a group reservation, one room reservation saga is started per room"
+http POST http://localhost:8080/api/v1/group-reservations < group-reservation.json
+
 # curl
 #echo
 #echo "Place a room reservation successfully"
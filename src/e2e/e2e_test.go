@@ -0,0 +1,172 @@
+package e2e_test
+
+import (
+	"context"
+	"database/sql"
+	hotelapp "go.example/saga/hotel/pkg/app"
+	hotelschema "go.example/saga/hotel/schema"
+	paymentapp "go.example/saga/payment/pkg/app"
+	paymentschema "go.example/saga/payment/schema"
+	"go.example/saga/pkg/messaging/memory"
+	"go.example/saga/pkg/saga"
+	"go.example/saga/pkg/store/postgres"
+	"go.example/saga/pkg/store/postgres/postgrestest"
+	reservationapp "go.example/saga/reservation/pkg/app"
+	"go.example/saga/reservation/pkg/model"
+	reservationschema "go.example/saga/reservation/schema"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// services the three services wired together over the in-memory bus, their outbox relays publishing to it
+type services struct {
+	reservation *reservationapp.Service
+	hotel       *postgres.Store
+}
+
+// TestReservation runs the reservation sagas end to end within the test process: the reservation, hotel and payment
+// services consume the in-memory bus the outbox relays publish to, in place of Kafka and debezium. The services use
+// schemas dedicated to the test in the docker compose databases of the POSTGRES_HOST env, the test is skipped when
+// POSTGRES_HOST is not set.
+func TestReservation(t *testing.T) {
+	for _, mode := range []saga.Mode{saga.ModeOrchestration, saga.ModeChoreography} {
+		t.Run(string(mode), func(t *testing.T) {
+			s := start(t, mode)
+
+			booked := s.reserve(t, 1, "************7999")
+			s.await(t, booked, model.ReservationStatusSucceed)
+			if available(t, s.hotel, 1) {
+				t.Error("room 1 available, want booked")
+			}
+
+			// the payment of the cards ending with 9999 fails, the room booked is released
+			paymentFailed := s.reserve(t, 3, "************9999")
+			s.await(t, paymentFailed, model.ReservationStatusFailed)
+			if !available(t, s.hotel, 3) {
+				t.Error("room 3 not available, want released")
+			}
+
+			roomTaken := s.reserve(t, 2, "************7999")
+			s.await(t, roomTaken, model.ReservationStatusFailed)
+		})
+	}
+}
+
+// start wires and starts the services, they are stopped once the test completes
+func start(t *testing.T, mode saga.Mode) *services {
+	t.Helper()
+	reservationStore := testStore(t, "5432", "reservation", reservationschema.Migrations())
+	hotelStore := testStore(t, "5433", "hotel", hotelschema.Migrations())
+	paymentStore := testStore(t, "5434", "payment", paymentschema.Migrations())
+
+	bus := memory.NewBus(100 * time.Millisecond)
+	roomBookingInbox := hotelapp.Topic{GroupID: "hotel-service-br", InboxTopic: "room-booking.inbox.events"}
+	hotelPayment := hotelapp.Topic{GroupID: "hotel-service-p", InboxTopic: "payment.outbox.events"}
+	hotelService := hotelapp.New(mode, hotelStore, bus, roomBookingInbox, hotelPayment)
+
+	paymentInbox := paymentapp.Topic{GroupID: "payment-service", InboxTopic: "payment.inbox.events"}
+	paymentRoomBooking := paymentapp.Topic{GroupID: "payment-service-rb", InboxTopic: "room-booking.outbox.events"}
+	paymentService := paymentapp.New(mode, paymentStore, bus, paymentInbox, paymentRoomBooking)
+
+	cfg := reservationapp.Config{Mode: mode, Protocol: saga.ProtocolSaga}
+	roomBooking := reservationapp.Topic{GroupID: "reservation-service-rb", InboxTopic: "room-booking.outbox.events"}
+	payment := reservationapp.Topic{GroupID: "reservation-service-p", InboxTopic: "payment.outbox.events"}
+	reservationService := reservationapp.New(cfg, reservationStore, bus, roomBooking, payment)
+
+	// the reservation outbox events are routed to the participants inbox topics, the participants publish theirs
+	// to their outbox topics
+	relay := postgres.RelayProps{Interval: 50 * time.Millisecond, DeletePublished: true, Listen: true}
+	reservationRelay := relay
+	reservationRelay.Topic = "${aggregatetype}.inbox.events"
+
+	tasks := []func(ctx context.Context) error{
+		postgres.NewRelay(reservationStore, bus, reservationRelay).Start,
+		postgres.NewRelay(hotelStore, bus, relay).Start,
+		postgres.NewRelay(paymentStore, bus, relay).Start,
+		hotelService.Controller.StartIngestion,
+		paymentService.Controller.StartIngestion,
+		reservationService.Controller.StartBookingIngestion,
+		reservationService.Controller.StartPaymentIngestion,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task func(ctx context.Context) error) {
+			defer wg.Done()
+			if err := task(ctx); err != nil {
+				t.Error(err)
+			}
+		}(task)
+	}
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	return &services{reservation: reservationService, hotel: hotelStore}
+}
+
+// reserve posts the reservation of the room of hotel 1, paid with the credit card
+func (s *services) reserve(t *testing.T, roomID int64, creditCardNO string) string {
+	t.Helper()
+	r, err := s.reservation.Controller.PostReservation(context.Background(), model.ReservationCmd{
+		HotelID:      1,
+		RoomID:       roomID,
+		StartDate:    "2023-12-16",
+		EndDate:      "2023-12-17",
+		GuestID:      10000001,
+		PaymentDue:   1702632793441,
+		CreditCardNO: creditCardNO,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.ID.String()
+}
+
+// await polls the reservation until it has the status
+func (s *services) await(t *testing.T, reservationID string, status model.ReservationStatus) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		r, err := s.reservation.Controller.GetReservation(context.Background(), reservationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status == status {
+			return
+		}
+		if r.Status != model.ReservationStatusPending || time.Now().After(deadline) {
+			t.Fatalf("reservation %s status %s, want %s", reservationID, r.Status, status)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// testStore the store of a schema dedicated to the test in the service database of the docker compose
+func testStore(t *testing.T, port string, service string, migrations fs.FS) *postgres.Store {
+	t.Helper()
+	return postgrestest.NewStore(t, postgres.StoreProps{
+		Port:     port,
+		User:     service + "user",
+		Password: "secret",
+		Dbname:   service + "db",
+	}, migrations)
+}
+
+// available reports whether the hotel room is available
+func available(t *testing.T, hotel *postgres.Store, roomID int64) bool {
+	t.Helper()
+	available, err := postgres.Transact(context.Background(), hotel, postgres.ReadOnlyTx, func(tx *sql.Tx) (bool, error) {
+		var available bool
+		return available, tx.QueryRow("SELECT available FROM room WHERE id = $1", roomID).Scan(&available)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return available
+}
@@ -1,12 +1,23 @@
 package main
 
-import "go.example/saga/pkg/store/postgres"
+import (
+	kafkamsg "go.example/saga/pkg/messaging/kafka"
+	"go.example/saga/pkg/saga"
+	"go.example/saga/pkg/store/postgres"
+	"time"
+)
 
 type (
 	config struct {
-		Server serverConfig `yaml:"server"`
-		Store  storeConfig  `yaml:"store"`
-		Kafka  kafkaConfig  `yaml:"kafka"`
+		Mode      saga.Mode       `yaml:"mode"`
+		Server    serverConfig    `yaml:"server"`
+		Store     storeConfig     `yaml:"store"`
+		Kafka     kafkaConfig     `yaml:"kafka"`
+		Retention retentionConfig `yaml:"retention"`
+		Outbox    outboxConfig    `yaml:"outbox"`
+		Inbox     inboxConfig     `yaml:"inbox"`
+		// ShutdownTimeout the deadline of the graceful shutdown, the events in flight are then redelivered on restart
+		ShutdownTimeout time.Duration `yaml:"shutdown-timeout"`
 	}
 
 	serverConfig struct {
@@ -19,17 +30,82 @@ type (
 		User     string `yaml:"user"`
 		Password string `yaml:"password"`
 		Dbname   string `yaml:"dbname"`
+		// Migrate applies the pending schema migrations on startup
+		Migrate bool `yaml:"migrate"`
 	}
 
 	kafkaConfig struct {
-		BoostrapServers string     `yaml:"boostrap-servers"`
-		RoomBooking     sagaConfig `yaml:"room-booking"`
+		BoostrapServers string           `yaml:"boostrap-servers"`
+		Consumer        consumerConfig   `yaml:"consumer"`
+		DeadLetter      deadLetterConfig `yaml:"dead-letter"`
+		Retry           retryConfig      `yaml:"retry"`
+		RoomBooking     sagaConfig       `yaml:"room-booking"`
+		// Payment events are consumed in choreography mode only
+		Payment sagaConfig `yaml:"payment"`
+	}
+
+	// consumerConfig the Kafka consumer settings of the ingesters
+	consumerConfig struct {
+		AutoOffsetReset string            `yaml:"auto-offset-reset"`
+		SessionTimeout  time.Duration     `yaml:"session-timeout"`
+		MaxPollInterval time.Duration     `yaml:"max-poll-interval"`
+		Config          map[string]string `yaml:"config"`
+		Workers         int               `yaml:"workers"`
+	}
+
+	// deadLetterConfig the messages failing permanently or MaxAttempts times are sent to the <topic>.dlq topic
+	deadLetterConfig struct {
+		Enabled     bool `yaml:"enabled"`
+		MaxAttempts int  `yaml:"max-attempts"`
+	}
+
+	// retryConfig the failed messages are retried through the retry topics of the delays (<topic>.retry.<delay>)
+	retryConfig struct {
+		Delays []time.Duration `yaml:"delays"`
 	}
 
 	sagaConfig struct {
 		GroupID    string `yaml:"group-id"`
 		InboxTopic string `yaml:"inbox-topic"`
 	}
+
+	outboxConfig struct {
+		Relay relayConfig `yaml:"relay"`
+		// CloudEvents requires the relay, the debezium connectors publish with the EventRouter conventions
+		CloudEvents cloudEventsConfig `yaml:"cloudevents"`
+	}
+
+	// relayConfig the Go outbox relay, an alternative to the debezium connector
+	relayConfig struct {
+		Enabled         bool          `yaml:"enabled"`
+		Topic           string        `yaml:"topic"`
+		Interval        time.Duration `yaml:"interval"`
+		BatchSize       int           `yaml:"batch-size"`
+		DeletePublished bool          `yaml:"delete-published"`
+		Listen          bool          `yaml:"listen"`
+	}
+
+	// cloudEventsConfig the outbox events are published as CloudEvents 1.0 of the mode (binary or structured)
+	cloudEventsConfig struct {
+		Mode   string `yaml:"mode"`
+		Source string `yaml:"source"`
+	}
+
+	// inboxConfig the received messages are stored in the inbox and processed asynchronously, with retries
+	inboxConfig struct {
+		Enabled     bool          `yaml:"enabled"`
+		Workers     int           `yaml:"workers"`
+		Interval    time.Duration `yaml:"interval"`
+		MaxAttempts int           `yaml:"max-attempts"`
+		Backoff     time.Duration `yaml:"backoff"`
+		MaxBackoff  time.Duration `yaml:"max-backoff"`
+	}
+
+	retentionConfig struct {
+		Interval       time.Duration `yaml:"interval"`
+		EventLogMaxAge time.Duration `yaml:"event-log-max-age"`
+		OutboxMaxAge   time.Duration `yaml:"outbox-max-age"`
+	}
 )
 
 func (s storeConfig) StoreProps() postgres.StoreProps {
@@ -42,9 +118,61 @@ func (s storeConfig) StoreProps() postgres.StoreProps {
 	}
 }
 
+func (k kafkaConfig) ConsumerProps() kafkamsg.ConsumerProps {
+	return kafkamsg.ConsumerProps{
+		BootstrapServers: k.BoostrapServers,
+		AutoOffsetReset:  k.Consumer.AutoOffsetReset,
+		SessionTimeout:   k.Consumer.SessionTimeout,
+		MaxPollInterval:  k.Consumer.MaxPollInterval,
+		Config:           k.Consumer.Config,
+		Workers:          k.Consumer.Workers,
+	}
+}
+
+// RetryMaxWait the longest a retried message is held by a delivery, half the consumer max poll interval
+func (k kafkaConfig) RetryMaxWait() time.Duration {
+	return k.Consumer.MaxPollInterval / 2
+}
+
+// RelayProps the relay settings, the events are published as CloudEvents when their mode is set
+func (o outboxConfig) RelayProps() postgres.RelayProps {
+	r := o.Relay
+	return postgres.RelayProps{
+		Topic:           r.Topic,
+		Interval:        r.Interval,
+		BatchSize:       r.BatchSize,
+		DeletePublished: r.DeletePublished,
+		Listen:          r.Listen,
+		CloudEvents:     o.CloudEvents.Mode,
+		Source:          o.CloudEvents.Source,
+	}
+}
+
+func (i inboxConfig) InboxProps() postgres.InboxProps {
+	return postgres.InboxProps{
+		Workers:     i.Workers,
+		Interval:    i.Interval,
+		MaxAttempts: i.MaxAttempts,
+		Backoff:     i.Backoff,
+		MaxBackoff:  i.MaxBackoff,
+	}
+}
+
+// RetentionProps the retention settings, the outbox rows not yet published are kept when the relay is enabled
+func (r retentionConfig) RetentionProps(relay bool) postgres.RetentionProps {
+	return postgres.RetentionProps{
+		KeepUnpublished: relay,
+		Interval:        r.Interval,
+		EventLogMaxAge:  r.EventLogMaxAge,
+		OutboxMaxAge:    r.OutboxMaxAge,
+	}
+}
+
 func InMem() config {
 	return config{
-		Server: serverConfig{Port: 8081},
+		Mode:            saga.ModeOrchestration,
+		ShutdownTimeout: 30 * time.Second,
+		Server:          serverConfig{Port: 8081},
 		Store: storeConfig{
 			Host:     "localhost",
 			Port:     "5433",
@@ -58,6 +186,15 @@ func InMem() config {
 				GroupID:    "hotel-service-br",
 				InboxTopic: "room-booking.inbox.events",
 			},
+			Payment: sagaConfig{
+				GroupID:    "hotel-service-p",
+				InboxTopic: "payment.outbox.events",
+			},
+		},
+		Retention: retentionConfig{
+			Interval:       time.Hour,
+			EventLogMaxAge: 7 * 24 * time.Hour,
+			OutboxMaxAge:   time.Hour,
 		},
 	}
 }
@@ -0,0 +1,50 @@
+package hotel
+
+import (
+	"context"
+	"database/sql"
+	"go.example/saga/hotel/pkg/model"
+	"log"
+)
+
+// StartPaymentIngestion starts the ingestion of payment events (choreography mode),
+// the room booked for a failed payment is released.
+func (c *Controller) StartPaymentIngestion(ctx context.Context) error {
+	return c.paymentIngester.Consume(ctx, c.HandlePayment)
+}
+
+// HandlePayment releases the room of a failed payment, the other payment events are ignored,
+// the event is redelivered on error
+func (c *Controller) HandlePayment(ctx context.Context, e model.RoomBookingEvent) error {
+	if e.EventType != model.PaymentFailedEventType {
+		return nil
+	}
+
+	log.Printf("on PaymentFailed: %d reservation: %s", e.Payload.RoomID, e.MsgID)
+	if _, err := c.onPaymentFailed(ctx, e); err != nil {
+		log.Printf("Failed to process message key %s eventID %s: %v", e.MsgID, e.EventID, err)
+		return err
+	}
+	return nil
+}
+
+// onPaymentFailed compensates the room booking and publishes the RoomReleased event.
+func (c *Controller) onPaymentFailed(ctx context.Context, e model.RoomBookingEvent) (interface{}, error) {
+	return c.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		// ensure idempotence (at least once semantic)
+		if first, err := c.eventLogger.TryConsume(ctx, tx, e.GroupID, e.EventID); err != nil || !first {
+			return nil, err
+		}
+
+		status, err := c.cancel(ctx, tx, e)
+		if err != nil {
+			return nil, err
+		}
+		outboxEvent := c.outboxEvent(e, status)
+		if err := outboxEvent.Persist(ctx, tx); err != nil {
+			return nil, err
+		}
+
+		return status, nil
+	})
+}
@@ -3,20 +3,23 @@ package hotel
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"go.example/saga/hotel/pkg/model"
+	"go.example/saga/pkg/jsonmap"
+	"go.example/saga/pkg/messaging"
+	"go.example/saga/pkg/saga"
 	"go.example/saga/pkg/store/postgres"
 	"log"
 )
 
 // roomBookIngester defines the interface for ingesting room booking events.
 type roomBookIngester interface {
-	Ingest(ctx context.Context) (chan model.RoomBookingEvent, error)
+	Consume(ctx context.Context, handler messaging.EventHandler[model.RoomBookingEvent]) error
 }
 
 // eventLogger defines the interface for ensuring the exact once event consuming as part of current tx
 type eventLogger interface {
-	IsConsumed(ctx context.Context, tx *sql.Tx, eventID string) bool
-	Consume(ctx context.Context, tx *sql.Tx, eventID string) error
+	TryConsume(ctx context.Context, tx *sql.Tx, consumerGroup string, eventID string) (bool, error)
 }
 
 // repository
@@ -24,37 +27,38 @@ type repository interface {
 	IsRoomAvailable(ctx context.Context, tx *sql.Tx, roomID model.RoomID) (bool, error)
 	BookRoom(ctx context.Context, tx *sql.Tx, roomID model.RoomID) error
 	ReleaseRoom(ctx context.Context, tx *sql.Tx, roomID model.RoomID) error
+	HoldRoom(ctx context.Context, tx *sql.Tx, roomID model.RoomID, sagaID string) (bool, error)
+	UpdateHold(ctx context.Context, tx *sql.Tx, sagaID string, status model.HoldStatus) (model.HoldStatus, error)
 }
 
 // Controller is responsible for handling room booking events.
 type Controller struct {
-	ingester    roomBookIngester
-	store       *postgres.Store
-	eventLogger eventLogger
-	repository  repository
+	mode            saga.Mode
+	ingester        roomBookIngester
+	paymentIngester roomBookIngester
+	store           *postgres.Store
+	eventLogger     eventLogger
+	repository      repository
 }
 
-// New creates a new instance of the hotel service controller.
-func New(ingester roomBookIngester, store *postgres.Store, eventLogger eventLogger, repository repository) *Controller {
-	return &Controller{ingester, store, eventLogger, repository}
+// New creates a new instance of the hotel service controller,
+// the payment ingester is used only in choreography mode and can be nil otherwise.
+func New(mode saga.Mode, ingester roomBookIngester, paymentIngester roomBookIngester, store *postgres.Store, eventLogger eventLogger, repository repository) *Controller {
+	return &Controller{mode, ingester, paymentIngester, store, eventLogger, repository}
 }
 
-// StartIngestion starts the ingestion of room booking events.
+// StartIngestion starts the ingestion of room booking events, an event is acknowledged once its TX committed.
 func (c *Controller) StartIngestion(ctx context.Context) error {
-	// Ingest room booking events through the provided ingester.
-	ch, err := c.ingester.Ingest(ctx)
-	if err != nil {
-		return err
-	}
+	return c.ingester.Consume(ctx, c.HandleRoomBooking)
+}
 
-	// Process each room booking event received from the ingester channel.
-	for e := range ch {
-		log.Printf("on RoomBookingEvent: %d eventType: %s payload: %v", e.Payload.RoomID, e.Payload.Type, e)
-		if _, err := c.onEvent(ctx, e); err != nil {
-			log.Printf("Failed to process message key %s eventID %s: %v", e.MsgID, e.EventID, err)
-		}
+// HandleRoomBooking processes a room booking event, the event is redelivered on error
+func (c *Controller) HandleRoomBooking(ctx context.Context, e model.RoomBookingEvent) error {
+	log.Printf("on RoomBookingEvent: %d eventType: %s payload: %v", e.Payload.RoomID, e.Payload.Type, e)
+	if _, err := c.onEvent(ctx, e); err != nil {
+		log.Printf("Failed to process message key %s eventID %s: %v", e.MsgID, e.EventID, err)
+		return err
 	}
-
 	return nil
 }
 
@@ -62,19 +66,17 @@ func (c *Controller) StartIngestion(ctx context.Context) error {
 func (c *Controller) onEvent(ctx context.Context, e model.RoomBookingEvent) (interface{}, error) { // Perform the transaction using the Datasource.
 	return c.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
 		// ensure idempotence (at least once semantic)
-		if c.eventLogger.IsConsumed(ctx, tx, e.EventID) {
-			return nil, nil
+		if first, err := c.eventLogger.TryConsume(ctx, tx, e.GroupID, e.EventID); err != nil || !first {
+			return nil, err
 		}
 
-		// Process the room booking event and get its status.
-		status, _ := c.handle(ctx, tx, e)
-		outboxEvent := postgres.NewEvent(e.MsgID, "room-booking", "RoomUpdated", status.ToJSONMap())
-		if err := outboxEvent.Persist(ctx, tx); err != nil {
+		// Process the room booking event and get its status, the event is retried on a transient failure
+		status, err := c.handle(ctx, tx, e)
+		if err != nil {
 			return nil, err
 		}
-
-		// consume the event
-		if err := c.eventLogger.Consume(ctx, tx, e.EventID); err != nil {
+		outboxEvent := c.outboxEvent(e, status)
+		if err := outboxEvent.Persist(ctx, tx); err != nil {
 			return nil, err
 		}
 
@@ -82,27 +84,102 @@ func (c *Controller) onEvent(ctx context.Context, e model.RoomBookingEvent) (int
 	})
 }
 
+// outboxEvent builds the event published for the processed room booking event:
+// a reply to the orchestrator, or a domain event carrying the reservation in choreography mode.
+func (c *Controller) outboxEvent(e model.RoomBookingEvent, status model.BookingStatus) postgres.OutboxEvent {
+	if c.mode != saga.ModeChoreography {
+		return postgres.NewEvent(e.MsgID, "room-booking", "RoomUpdated", status.ToJSONMap(), e.Headers.Caused(e.EventID))
+	}
+
+	payload := jsonmap.JSONMap{}
+	for k, v := range e.Data {
+		payload[k] = v
+	}
+	payload["status"] = string(status)
+	return postgres.NewEvent(e.MsgID, "room-booking", status.EventType(), payload, e.Headers.Caused(e.EventID))
+}
+
 // handle processes a room booking event and updates the room availability.
 func (c *Controller) handle(ctx context.Context, tx *sql.Tx, e model.RoomBookingEvent) (model.BookingStatus, error) {
+	switch e.Payload.Type {
+	case postgres.RequestEventType:
+		return c.book(ctx, tx, e)
+	case postgres.TryEventType:
+		return c.try(ctx, tx, e)
+	case postgres.ConfirmEventType:
+		return c.confirm(ctx, tx, e)
+	default:
+		return c.cancel(ctx, tx, e)
+	}
+}
+
+// book books the room if available.
+func (c *Controller) book(ctx context.Context, tx *sql.Tx, e model.RoomBookingEvent) (model.BookingStatus, error) {
 	available, err := c.repository.IsRoomAvailable(ctx, tx, e.Payload.RoomID)
 	if err != nil {
 		return model.BookingStatusRejected, err // in case of failures
 	}
 
-	var status model.BookingStatus
-	if e.Payload.Type == postgres.RequestEventType {
-		status = model.BookingStatusRejected
-		if available {
-			if err := c.repository.BookRoom(ctx, tx, e.Payload.RoomID); err == nil {
-				status = model.BookingStatusBooked
-			}
-		}
-	} else {
-		// Release the room and publish a cancellation event.
-		status = model.BookingStatusCancelled
-		if err := c.repository.ReleaseRoom(ctx, tx, e.Payload.RoomID); err != nil {
-			status = model.BookingStatusRejected
+	if !available {
+		return model.BookingStatusRejected, nil
+	}
+	if err := c.repository.BookRoom(ctx, tx, e.Payload.RoomID); err != nil {
+		return model.BookingStatusRejected, err
+	}
+	return model.BookingStatusBooked, nil
+}
+
+// try tentatively holds the room if available (TCC try), the room stays available until confirmed.
+// A room held by a concurrent try is rejected.
+func (c *Controller) try(ctx context.Context, tx *sql.Tx, e model.RoomBookingEvent) (model.BookingStatus, error) {
+	available, err := c.repository.IsRoomAvailable(ctx, tx, e.Payload.RoomID)
+	if err != nil || !available {
+		return model.BookingStatusRejected, err
+	}
+
+	held, err := c.repository.HoldRoom(ctx, tx, e.Payload.RoomID, e.MsgID)
+	if err != nil || !held {
+		return model.BookingStatusRejected, err
+	}
+	return model.BookingStatusHeld, nil
+}
+
+// confirm books the held room (TCC confirm), a confirm without a hold (never tried, or cancelled) is a
+// permanent failure: the TX is rolled back and the event dead lettered.
+func (c *Controller) confirm(ctx context.Context, tx *sql.Tx, e model.RoomBookingEvent) (model.BookingStatus, error) {
+	prev, err := c.repository.UpdateHold(ctx, tx, e.MsgID, model.HoldStatusConfirmed)
+	if err != nil {
+		return model.BookingStatusRejected, err
+	}
+
+	switch prev {
+	case model.HoldStatusHeld:
+		if err := c.repository.BookRoom(ctx, tx, e.Payload.RoomID); err != nil {
+			return model.BookingStatusRejected, err
 		}
+	case model.HoldStatusConfirmed:
+		// already confirmed, the reply is published again
+	default:
+		return model.BookingStatusRejected, messaging.Permanent(fmt.Errorf("confirm saga %s room %d: no hold (%q)", e.MsgID, e.Payload.RoomID, prev))
+	}
+	return model.BookingStatusConfirmed, nil
+}
+
+// cancel releases the held room (TCC cancel) or the booked room (compensation).
+func (c *Controller) cancel(ctx context.Context, tx *sql.Tx, e model.RoomBookingEvent) (model.BookingStatus, error) {
+	prev, err := c.repository.UpdateHold(ctx, tx, e.MsgID, model.HoldStatusCancelled)
+	if err != nil {
+		return model.BookingStatusRejected, err
+	}
+
+	// a held room was never booked, nothing to release
+	if prev == model.HoldStatusHeld || prev == model.HoldStatusCancelled {
+		return model.BookingStatusCancelled, nil
+	}
+
+	// Release the room and publish a cancellation event.
+	if err := c.repository.ReleaseRoom(ctx, tx, e.Payload.RoomID); err != nil {
+		return model.BookingStatusRejected, err
 	}
-	return status, nil
+	return model.BookingStatusCancelled, nil
 }
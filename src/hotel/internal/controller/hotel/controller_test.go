@@ -0,0 +1,94 @@
+package hotel
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go.example/saga/hotel/internal/repository/postgres"
+	"go.example/saga/hotel/pkg/model"
+	"go.example/saga/hotel/schema"
+	"go.example/saga/pkg/saga"
+	store "go.example/saga/pkg/store/postgres"
+	"go.example/saga/pkg/store/postgres/postgrestest"
+	"sync"
+	"testing"
+)
+
+// testController the controller of a hotel schema dedicated to the test, in the database of the POSTGRES_HOST env
+// (the docker compose hotel-db), the test is skipped when POSTGRES_HOST is not set
+func testController(t *testing.T) (*Controller, *store.Store) {
+	t.Helper()
+	st := postgrestest.NewStore(t, store.StoreProps{
+		Port:     "5433",
+		User:     "hoteluser",
+		Password: "secret",
+		Dbname:   "hoteldb",
+	}, schema.Migrations())
+	return New(saga.ModeOrchestration, nil, nil, st, store.NewEventLogs(), postgres.New()), st
+}
+
+// roomBookingEvent a room booking command of a new saga
+func roomBookingEvent(eventType string, roomID model.RoomID) model.RoomBookingEvent {
+	return model.RoomBookingEvent{
+		GroupID: "hotel-service-br",
+		EventID: uuid.NewString(),
+		MsgID:   uuid.NewString(),
+		Payload: model.EventPayload{HotelID: 1, RoomID: roomID, Type: store.EventType(eventType)},
+	}
+}
+
+func TestUnknownRoomIsRejected(t *testing.T) {
+	c, st := testController(t)
+
+	for _, eventType := range []string{store.RequestEventType, store.TryEventType} {
+		t.Run(eventType, func(t *testing.T) {
+			e := roomBookingEvent(eventType, 999)
+			status, err := c.onEvent(context.Background(), e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != model.BookingStatus(model.BookingStatusRejected) {
+				t.Errorf("status %v, want %s", status, model.BookingStatusRejected)
+			}
+			if n := postgrestest.Count(t, st, "SELECT count(*) FROM outboxevent WHERE aggregateid=$1", e.MsgID); n != 1 {
+				t.Errorf("%d replies, want 1", n)
+			}
+		})
+	}
+}
+
+func TestConcurrentTriesHoldTheRoomOnce(t *testing.T) {
+	c, st := testController(t)
+
+	const tries = 8
+	statuses := make(chan interface{}, tries)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < tries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			status, err := c.onEvent(context.Background(), roomBookingEvent(store.TryEventType, 1))
+			if err != nil {
+				t.Error(err)
+			}
+			statuses <- status
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(statuses)
+
+	held := 0
+	for status := range statuses {
+		if status == model.BookingStatus(model.BookingStatusHeld) {
+			held++
+		}
+	}
+	if held != 1 {
+		t.Errorf("%d tries held the room, want 1", held)
+	}
+	if n := postgrestest.Count(t, st, "SELECT count(*) FROM roomhold WHERE room_id=1 AND status='HELD'"); n != 1 {
+		t.Errorf("%d holds, want 1", n)
+	}
+}
@@ -0,0 +1,31 @@
+package kafka
+
+import (
+	"encoding/json"
+	"go.example/saga/hotel/pkg/model"
+	"go.example/saga/pkg/jsonmap"
+	"go.example/saga/pkg/messaging"
+)
+
+// DecodeRoomBookingEvent decodes the room booking event of the received message
+func DecodeRoomBookingEvent(m messaging.Message) (model.RoomBookingEvent, error) {
+	var payload model.EventPayload
+	if err := json.Unmarshal(m.Value, &payload); err != nil {
+		return model.RoomBookingEvent{}, err
+	}
+	// keep the whole event payload, choreography events carry it to the next participant
+	var data jsonmap.JSONMap
+	if err := json.Unmarshal(m.Value, &data); err != nil {
+		return model.RoomBookingEvent{}, err
+	}
+	return model.RoomBookingEvent{
+		GroupID:   m.Group,
+		EventID:   m.EventID(),
+		EventType: m.EventType(),
+		MsgID:     m.Key,
+		Timestamp: m.Timestamp,
+		Payload:   payload,
+		Headers:   m.Metadata(),
+		Data:      data,
+	}, nil
+}
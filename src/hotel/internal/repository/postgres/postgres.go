@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"go.example/saga/hotel/pkg/model"
 )
 
@@ -15,11 +16,14 @@ func New() *Repository {
 	return &Repository{}
 }
 
-// IsRoomAvailable check if provided RoomID is available inside the provided TX
+// IsRoomAvailable check if provided RoomID is available inside the provided TX, an unknown room is not available
 func (r Repository) IsRoomAvailable(ctx context.Context, tx *sql.Tx, roomID model.RoomID) (bool, error) {
 	var available bool
-	row := tx.QueryRowContext(ctx, "SELECT available FROM room WHERE id=$1", roomID)
-	if err := row.Scan(&available); err != nil {
+	q := "SELECT available AND NOT EXISTS (SELECT 1 FROM roomhold WHERE room_id=$1 AND status=$2) FROM room WHERE id=$1"
+	row := tx.QueryRowContext(ctx, q, roomID, model.HoldStatusHeld)
+	if err := row.Scan(&available); errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
 
@@ -37,3 +41,30 @@ func (r Repository) ReleaseRoom(ctx context.Context, tx *sql.Tx, roomID model.Ro
 	_, err := tx.ExecContext(ctx, "UPDATE room SET available=true WHERE id=$1", roomID)
 	return err
 }
+
+// HoldRoom tentatively reserve the provided roomID for the saga inside the provided TX, returns false when the room
+// is already held: the hold of a concurrent TX waits for the other one to commit (unique HELD hold per room)
+func (r Repository) HoldRoom(ctx context.Context, tx *sql.Tx, roomID model.RoomID, sagaID string) (bool, error) {
+	q := `INSERT INTO roomhold(saga_id, room_id, status) VALUES ($1,$2,$3)
+		ON CONFLICT (room_id) WHERE status = 'HELD' DO NOTHING`
+	res, err := tx.ExecContext(ctx, q, sagaID, roomID, model.HoldStatusHeld)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UpdateHold change the status of the saga room hold and returns the previous status, empty if there is no hold
+func (r Repository) UpdateHold(ctx context.Context, tx *sql.Tx, sagaID string, status model.HoldStatus) (model.HoldStatus, error) {
+	var prev model.HoldStatus
+	row := tx.QueryRowContext(ctx, "SELECT status FROM roomhold WHERE saga_id=$1 FOR UPDATE", sagaID)
+	if err := row.Scan(&prev); errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	_, err := tx.ExecContext(ctx, "UPDATE roomhold SET status=$1 WHERE saga_id=$2", status, sagaID)
+	return prev, err
+}
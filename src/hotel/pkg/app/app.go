@@ -0,0 +1,40 @@
+// Package app wires the hotel service, run by the service main or within a single process with the other services
+package app
+
+import (
+	"go.example/saga/hotel/internal/controller/hotel"
+	"go.example/saga/hotel/internal/handler/ingester/kafka"
+	"go.example/saga/hotel/internal/repository/postgres"
+	"go.example/saga/hotel/pkg/model"
+	"go.example/saga/pkg/messaging"
+	"go.example/saga/pkg/saga"
+	store "go.example/saga/pkg/store/postgres"
+)
+
+// Topic a consumed topic and the consumer group consuming it
+type Topic struct {
+	GroupID    string
+	InboxTopic string
+}
+
+// Service the hotel service controller and its ingesters
+type Service struct {
+	Controller  *hotel.Controller
+	RoomBooking *messaging.Ingester[model.RoomBookingEvent]
+	// Payment the payment events ingester of the choreography mode, nil otherwise
+	Payment *messaging.Ingester[model.RoomBookingEvent]
+}
+
+// New wires the hotel service, the events are consumed through the subscriber (e.g. kafka or the in-memory bus)
+func New(mode saga.Mode, st *store.Store, subscriber messaging.Subscriber, roomBooking Topic, payment Topic) *Service {
+	roomBookIngester := messaging.NewIngester(subscriber, roomBooking.GroupID, roomBooking.InboxTopic, kafka.DecodeRoomBookingEvent)
+
+	// in choreography mode the room booked for a failed payment is released
+	var paymentIngester *messaging.Ingester[model.RoomBookingEvent]
+	if mode == saga.ModeChoreography {
+		paymentIngester = messaging.NewIngester(subscriber, payment.GroupID, payment.InboxTopic, kafka.DecodeRoomBookingEvent)
+	}
+
+	ctrl := hotel.New(mode, roomBookIngester, paymentIngester, st, store.NewEventLogs(), postgres.New())
+	return &Service{Controller: ctrl, RoomBooking: roomBookIngester, Payment: paymentIngester}
+}
@@ -34,10 +34,14 @@ type RoomID int32
 
 // RoomBookingEvent
 type RoomBookingEvent struct {
-	EventID   string       `json:"eventId"`
-	MsgID     string       `json:"msgId"`
-	Timestamp time.Time    `json:"timestamp"`
-	Payload   EventPayload `json:"payload"`
+	GroupID   string           `json:"groupId"`
+	EventID   string           `json:"eventId"`
+	EventType string           `json:"eventType"`
+	MsgID     string           `json:"msgId"`
+	Timestamp time.Time        `json:"timestamp"`
+	Payload   EventPayload     `json:"payload"`
+	Headers   postgres.Headers `json:"headers"`
+	Data      jsonmap.JSONMap  `json:"-"`
 }
 
 // EventPayload
@@ -57,8 +61,42 @@ const (
 	BookingStatusBooked    = "BOOKED"
 	BookingStatusRejected  = "REJECTED"
 	BookingStatusCancelled = "CANCELLED"
+	BookingStatusHeld      = "HELD"
+	BookingStatusConfirmed = "CONFIRMED"
+)
+
+// HoldStatus defines the status of a TCC tentative room reservation
+type HoldStatus string
+
+const (
+	HoldStatusHeld      = "HELD"
+	HoldStatusConfirmed = "CONFIRMED"
+	HoldStatusCancelled = "CANCELLED"
 )
 
 func (status BookingStatus) ToJSONMap() jsonmap.JSONMap {
 	return jsonmap.JSONMap{"status": string(status)}
 }
+
+// Choreography domain event types
+const (
+	// ReservationCreatedEventType published by the reservation service, the room is booked
+	ReservationCreatedEventType = "ReservationCreated"
+	// RoomBookedEventType published by the hotel service, the payment is executed
+	RoomBookedEventType   = "RoomBooked"
+	RoomRejectedEventType = "RoomRejected"
+	RoomReleasedEventType = "RoomReleased"
+	// PaymentFailedEventType published by the payment service, the room is released
+	PaymentFailedEventType = "PaymentFailed"
+)
+
+// EventType returns the choreography domain event type published for the booking status
+func (status BookingStatus) EventType() string {
+	switch status {
+	case BookingStatusBooked:
+		return RoomBookedEventType
+	case BookingStatusCancelled:
+		return RoomReleasedEventType
+	}
+	return RoomRejectedEventType
+}
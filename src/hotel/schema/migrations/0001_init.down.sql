@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS outboxevent;
+DROP TABLE IF EXISTS eventlog;
+DROP TABLE IF EXISTS roomhold;
+DROP TABLE IF EXISTS room;
+DROP TABLE IF EXISTS hotel;
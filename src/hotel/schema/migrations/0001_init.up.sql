@@ -19,6 +19,16 @@ CREATE TABLE IF NOT EXISTS room
     FOREIGN KEY (hotel_id) REFERENCES hotel (id) ON UPDATE CASCADE ON DELETE CASCADE
 );
 
+-- TCC tentative room reservations, a HELD room is not available for other bookings until cancelled
+CREATE TABLE IF NOT EXISTS roomhold
+(
+    saga_id       VARCHAR(100) PRIMARY KEY,
+    creation_time TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    room_id       INT         NOT NULL,
+    status        VARCHAR(20) NOT NULL,
+    FOREIGN KEY (room_id) REFERENCES room (id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
 -- Infrastructure tables
 CREATE TABLE IF NOT EXISTS eventlog
 (
@@ -26,6 +36,8 @@ CREATE TABLE IF NOT EXISTS eventlog
     issued_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE INDEX IF NOT EXISTS eventlog_issued_on_idx ON eventlog (issued_on);
+
 CREATE TABLE IF NOT EXISTS outboxevent
 (
     id            UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
@@ -36,14 +48,7 @@ CREATE TABLE IF NOT EXISTS outboxevent
     payload       JSONB        NOT NULL
 );
 
+CREATE INDEX IF NOT EXISTS outboxevent_timestamp_idx ON outboxevent (timestamp);
+
 ALTER TABLE outboxevent
     REPLICA IDENTITY FULL;
-
--- DEMO data
-INSERT INTO hotel(id, name, address, location)
-VALUES (1, 'Bristol Central Park Hotel', 'str. Puskin 32, 2012', 'Chişinău');
-
-INSERT INTO room(id, name, number, floor, hotel_id, available)
-VALUES (1, 'Twin with view', 38, 5, 1, true),
-       (2, 'Deluxe', 25, 3, 1, false),
-       (3, 'Twin Deluxe', 27, 2, 1, true);
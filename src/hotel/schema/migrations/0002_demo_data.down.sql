@@ -0,0 +1,2 @@
+DELETE FROM room WHERE id IN (1, 2, 3);
+DELETE FROM hotel WHERE id = 1;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS outboxevent_pending_idx;
+
+ALTER TABLE outboxevent
+    DROP COLUMN IF EXISTS published_on;
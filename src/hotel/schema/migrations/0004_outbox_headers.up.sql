@@ -0,0 +1,3 @@
+-- outbox event metadata (trace, correlation, causation IDs, schema version, tenant) published as Kafka header
+ALTER TABLE outboxevent
+    ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}';
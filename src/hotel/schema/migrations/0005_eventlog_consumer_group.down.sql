@@ -0,0 +1,13 @@
+DELETE FROM eventlog e
+    USING eventlog d
+WHERE e.event_id = d.event_id
+  AND e.consumer_group > d.consumer_group;
+
+ALTER TABLE eventlog
+    DROP CONSTRAINT IF EXISTS eventlog_pkey;
+
+ALTER TABLE eventlog
+    ADD CONSTRAINT eventlog_pkey PRIMARY KEY (event_id);
+
+ALTER TABLE eventlog
+    DROP COLUMN IF EXISTS consumer_group;
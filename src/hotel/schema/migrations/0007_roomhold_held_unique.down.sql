@@ -0,0 +1 @@
+DROP INDEX IF EXISTS roomhold_room_id_held_idx;
@@ -0,0 +1,2 @@
+-- a room is held by a single saga at a time, the concurrent tries of the same room are rejected but one
+CREATE UNIQUE INDEX IF NOT EXISTS roomhold_room_id_held_idx ON roomhold (room_id) WHERE status = 'HELD';
@@ -0,0 +1,16 @@
+// Package schema embeds the hotel service database migrations
+package schema
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrations the numbered migrations, <version>_<name>.up.sql and <version>_<name>.down.sql
+func Migrations() fs.FS {
+	sub, _ := fs.Sub(migrations, "migrations")
+	return sub
+}
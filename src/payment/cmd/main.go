@@ -3,18 +3,30 @@ package main
 import (
 	"context"
 	"fmt"
-	"go.example/saga/payment/internal/controller/payment"
-	"go.example/saga/payment/internal/ingester/kafka"
-	"go.example/saga/payment/internal/repository/postgres"
+	"go.example/saga/payment/pkg/app"
+	"go.example/saga/payment/schema"
+	"go.example/saga/pkg/messaging"
+	kafkamsg "go.example/saga/pkg/messaging/kafka"
 	store "go.example/saga/pkg/store/postgres"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 )
 
 const serviceName = "hotel"
 
 func main() {
+	// the service exits non-zero when stopped by a failed background task, once the deferred cleanups ran
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	logger, _ := zap.NewProduction()
 	defer func() {
 		_ = logger.Sync()
@@ -30,27 +42,175 @@ func main() {
 		logger.Fatal("Failed to parse configuration", zap.Error(err))
 	}
 
-	logger.Info(fmt.Sprintf("Starting the %s service", serviceName))
-
-	roomBookIngester, err := kafka.NewIngester(
-		cfg.Kafka.BoostrapServers, cfg.Kafka.Payment.GroupID, cfg.Kafka.Payment.InboxTopic)
-	if err != nil {
-		logger.Fatal("Failed to init room booking kafka ingester", zap.Error(err))
-		return
+	// the debezium connectors publish with the EventRouter conventions, the CloudEvents are published by the Go relay only
+	if cfg.Outbox.CloudEvents.Mode != "" && !cfg.Outbox.Relay.Enabled {
+		logger.Fatal("The outbox CloudEvents require the outbox relay", zap.String("mode", cfg.Outbox.CloudEvents.Mode))
 	}
 
+	logger.Info(fmt.Sprintf("Starting the %s service", serviceName), zap.String("mode", string(cfg.Mode)))
+
 	st, err := store.NewStore(cfg.Store.StoreProps())
 	if err != nil {
 		logger.Fatal("Failed to open postgres configs", zap.Error(err))
 	}
+	defer func() {
+		_ = st.Close()
+	}()
+
+	migrator, err := store.NewMigrator(st, schema.Migrations())
+	if err != nil {
+		logger.Fatal("Failed to load the schema migrations", zap.Error(err))
+	}
+
+	// migrate up|down [steps]|status runs the schema migrations and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrator.Run(context.Background(), os.Args[2:], os.Stdout); err != nil {
+			logger.Fatal("Failed to migrate the schema", zap.Error(err))
+		}
+		return
+	}
+
+	if cfg.Store.Migrate {
+		if _, err := migrator.Up(context.Background()); err != nil {
+			logger.Fatal("Failed to migrate the schema", zap.Error(err))
+		}
+	}
 
-	repository := postgres.New()
-	eventLogger := store.NewEventLogs()
-	ctrl := payment.New(st, repository, roomBookIngester, eventLogger)
+	messageInbox := store.NewInbox(st, cfg.Inbox.InboxProps())
+
+	// inbox list|replay all|replay <id>... lists or replays the failed inbox messages and exits
+	if len(os.Args) > 1 && os.Args[1] == "inbox" {
+		if err := messageInbox.Run(context.Background(), os.Args[2:], os.Stdout); err != nil {
+			logger.Fatal("Failed to run the inbox command", zap.Error(err))
+		}
+		return
+	}
 
-	ctx := context.Background()
-	err = ctrl.StartIngestion(ctx)
+	kafkaPublisher, err := kafkamsg.NewPublisher(cfg.Kafka.BoostrapServers)
 	if err != nil {
-		logger.Fatal("Failed to start kafka ingester", zap.Error(err))
+		logger.Fatal("Failed to init kafka publisher", zap.Error(err))
+	}
+	defer kafkaPublisher.Close()
+
+	// dlq list <topic>|replay <topic> all|replay <topic> <partition>:<offset>... lists or re-injects the dead letters and exits
+	if len(os.Args) > 1 && os.Args[1] == "dlq" {
+		deadLetters := kafkamsg.NewDeadLetters(cfg.Kafka.ConsumerProps(), kafkaPublisher)
+		if err := deadLetters.Run(context.Background(), os.Args[2:], os.Stdout); err != nil {
+			logger.Fatal("Failed to run the dlq command", zap.Error(err))
+		}
+		return
+	}
+
+	var subscriber messaging.Subscriber = kafkamsg.NewSubscriber(cfg.Kafka.ConsumerProps())
+	// the messages failing permanently (undecodable) or MaxAttempts times are sent to the dead letter topic
+	if cfg.Kafka.DeadLetter.Enabled {
+		subscriber = messaging.NewDeadLetterSubscriber(subscriber, kafkaPublisher, cfg.Kafka.DeadLetter.MaxAttempts)
+	}
+	// the failed messages are retried through the delayed retry topics without blocking their partition
+	if len(cfg.Kafka.Retry.Delays) > 0 {
+		subscriber = messaging.NewRetrySubscriber(subscriber, kafkaPublisher, cfg.Kafka.RetryMaxWait(), cfg.Kafka.Retry.Delays...)
+	}
+	paymentTopic := app.Topic{GroupID: cfg.Kafka.Payment.GroupID, InboxTopic: cfg.Kafka.Payment.InboxTopic}
+	roomBooking := app.Topic{GroupID: cfg.Kafka.RoomBooking.GroupID, InboxTopic: cfg.Kafka.RoomBooking.InboxTopic}
+	svc := app.New(cfg.Mode, st, subscriber, paymentTopic, roomBooking)
+	inboxTopic, paymentIngester, ctrl := svc.InboxTopic, svc.Ingester, svc.Controller
+
+	// the service stops on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tasks := newTasks(stop)
+	retention := store.NewRetention(st, cfg.Retention.RetentionProps(cfg.Outbox.Relay.Enabled))
+	tasks.run("retention", func() error {
+		return retention.Start(ctx)
+	})
+
+	// the Go relay publishes the outbox events when Kafka Connect (debezium) is not deployed
+	if cfg.Outbox.Relay.Enabled {
+		relay := store.NewRelay(st, kafkaPublisher, cfg.Outbox.RelayProps())
+		tasks.run("outbox relay", func() error {
+			return relay.Start(ctx)
+		})
+	}
+
+	// the ingested messages are stored in the inbox and processed asynchronously by the inbox workers
+	if cfg.Inbox.Enabled {
+		tasks.run("payment inbox", func() error {
+			return messageInbox.Start(ctx, inboxTopic.GroupID, paymentIngester.Handler(ctrl.HandlePayment))
+		})
+
+		tasks.run("kafka ingester", func() error {
+			return paymentIngester.Receive(ctx, messageInbox.Receive)
+		})
+	} else {
+		tasks.run("kafka ingester", func() error {
+			return ctrl.StartIngestion(ctx)
+		})
+	}
+
+	<-ctx.Done()
+	logger.Info(fmt.Sprintf("Stopping the %s service", serviceName), zap.Duration("timeout", cfg.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if !tasks.drain(shutdownCtx.Done()) {
+		logger.Warn("Shutdown timeout exceeded, the events in flight are redelivered on restart")
+	}
+	if err := tasks.err(); err != nil {
+		logger.Error("Background task failed", zap.Error(err))
+		exitCode = 1
+	}
+}
+
+// tasks the background tasks the shutdown waits for, the first task failing stops the service
+type tasks struct {
+	wg   sync.WaitGroup
+	stop context.CancelFunc
+	mu   sync.Mutex
+	fail error
+}
+
+// newTasks constructor
+func newTasks(stop context.CancelFunc) *tasks {
+	return &tasks{stop: stop}
+}
+
+// run runs f in a goroutine, its error is recorded and stops the service
+func (t *tasks) run(name string, f func() error) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		if err := f(); err != nil {
+			t.mu.Lock()
+			if t.fail == nil {
+				t.fail = fmt.Errorf("%s: %w", name, err)
+			}
+			t.mu.Unlock()
+			t.stop()
+		}
+	}()
+}
+
+// err the error of the first task failing, nil when the service was stopped by a signal
+func (t *tasks) err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fail
+}
+
+// drain waits for the background tasks to return: the ingesters stop consuming, process the events in flight and
+// commit their offsets. Returns false when the deadline is exceeded, the unfinished events are redelivered on restart.
+func (t *tasks) drain(deadline <-chan struct{}) bool {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-deadline:
+		return false
 	}
 }
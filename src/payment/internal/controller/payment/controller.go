@@ -4,75 +4,105 @@ import (
 	"context"
 	"database/sql"
 	"go.example/saga/payment/pkg/model"
+	"go.example/saga/pkg/messaging"
+	"go.example/saga/pkg/saga"
 	"go.example/saga/pkg/store/postgres"
 	"log"
 )
 
 // eventLogger defines the interface for ensuring the exact once event consuming as part of current tx
 type eventLogger interface {
-	IsConsumed(ctx context.Context, tx *sql.Tx, eventID string) bool
-	Consume(ctx context.Context, tx *sql.Tx, eventID string) error
+	TryConsume(ctx context.Context, tx *sql.Tx, consumerGroup string, eventID string) (bool, error)
 }
 
 // repository
 type repository interface {
 	Add(ctx context.Context, tx *sql.Tx, p model.Payment) error
+	UpdateType(ctx context.Context, tx *sql.Tx, p model.Payment) (bool, error)
 }
 
 // roomBookIngester defines the interface for ingesting room booking events.
 type ingester interface {
-	Ingest(ctx context.Context) (chan model.PaymentEvent, error)
+	Consume(ctx context.Context, handler messaging.EventHandler[model.PaymentEvent]) error
 }
 
 // Controller is responsible for handling room booking events.
 type Controller struct {
+	mode        saga.Mode
 	store       *postgres.Store
 	repository  repository
 	ingester    ingester
 	eventLogger eventLogger
 }
 
-// New creates a new instance of the hotel service controller.
-func New(store *postgres.Store, repository repository, ingester ingester, eventLogger eventLogger) *Controller {
-	return &Controller{store, repository, ingester, eventLogger}
+// New creates a new instance of the hotel service controller,
+// in choreography mode the ingester provides the hotel RoomBooked events.
+func New(mode saga.Mode, store *postgres.Store, repository repository, ingester ingester, eventLogger eventLogger) *Controller {
+	return &Controller{mode, store, repository, ingester, eventLogger}
 }
 
-// StartIngestion starts the ingestion of room booking events.
+// StartIngestion starts the ingestion of room booking events, an event is acknowledged once its TX committed.
 func (c *Controller) StartIngestion(ctx context.Context) error {
-	// Ingest room booking events through the provided ingester.
-	ch, err := c.ingester.Ingest(ctx)
-	if err != nil {
+	return c.ingester.Consume(ctx, c.HandlePayment)
+}
+
+// HandlePayment processes the payment event, in choreography mode only the booked rooms are paid,
+// the event is redelivered on error
+func (c *Controller) HandlePayment(ctx context.Context, e model.PaymentEvent) error {
+	if c.mode == saga.ModeChoreography && e.EventType != model.RoomBookedEventType {
+		return nil
+	}
+
+	log.Printf("on PaymentEvent: %d eventType: %s payload: %v", e.Payload.ID, e.Payload.Type, e)
+	if _, err := c.onEvent(ctx, e); err != nil {
+		log.Printf("Failed to process message key %s eventID %s: %v", e.MsgID, e.EventID, err)
 		return err
 	}
+	return nil
+}
+
+// onEvent records the payment and publishes its status through the outbox.
+func (c *Controller) onEvent(ctx context.Context, e model.PaymentEvent) (interface{}, error) {
+	return c.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		// ensure idempotence (at least once semantic)
+		if first, err := c.eventLogger.TryConsume(ctx, tx, e.GroupID, e.EventID); err != nil || !first {
+			return nil, err
+		}
 
-	// Process each room booking event received from the ingester channel.
-	for e := range ch {
-		log.Printf("on PaymentEvent: %d eventType: %s payload: %v", e.Payload.ID, e.Payload.Type, e)
-
-		c.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
-			// ensure idempotence (at least once semantic)
-			if c.eventLogger.IsConsumed(ctx, tx, e.EventID) {
-				return nil, nil
-			}
-
-			if err := c.repository.Add(ctx, tx, e.Payload); err != nil {
-				return nil, err
-			}
-
-			// publish outbox event to debezium
-			status := e.Payload.PaymentStatus()
-			outboxEvent := postgres.NewEvent(e.MsgID, "payment", "PaymentUpdated", status.ToJSONMap())
-			if err := outboxEvent.Persist(ctx, tx); err != nil {
-				return nil, err
-			}
-
-			// consume the event
-			if err := c.eventLogger.Consume(ctx, tx, e.EventID); err != nil {
-				return nil, err
-			}
-			return status, nil
-		})
+		if err := c.save(ctx, tx, e.Payload); err != nil {
+			return nil, err
+		}
+
+		// publish outbox event to debezium
+		status := e.Payload.PaymentStatus()
+		outboxEvent := c.outboxEvent(e, status)
+		if err := outboxEvent.Persist(ctx, tx); err != nil {
+			return nil, err
+		}
+		return status, nil
+	})
+}
+
+// outboxEvent builds the event published for the payment status:
+// a reply to the orchestrator, or a domain event in choreography mode.
+func (c *Controller) outboxEvent(e model.PaymentEvent, status model.PaymentStatus) postgres.OutboxEvent {
+	if c.mode != saga.ModeChoreography {
+		return postgres.NewEvent(e.MsgID, "payment", "PaymentUpdated", status.ToJSONMap(), e.Headers.Caused(e.EventID))
 	}
+	return postgres.NewEvent(e.MsgID, "payment", status.EventType(), e.Payload.ToEventJSONMap(status), e.Headers.Caused(e.EventID))
+}
 
-	return nil
+// save records the payment: requested/tried payments are added, confirmed/cancelled ones update the existing payment
+func (c *Controller) save(ctx context.Context, tx *sql.Tx, p model.Payment) error {
+	if p.Type == postgres.RequestEventType || p.Type == postgres.TryEventType {
+		return c.repository.Add(ctx, tx, p)
+	}
+
+	updated, err := c.repository.UpdateType(ctx, tx, p)
+	if err != nil || updated {
+		return err
+	}
+
+	// cancel of an unknown payment is recorded as well
+	return c.repository.Add(ctx, tx, p)
 }
@@ -0,0 +1,24 @@
+package kafka
+
+import (
+	"encoding/json"
+	"go.example/saga/payment/pkg/model"
+	"go.example/saga/pkg/messaging"
+)
+
+// DecodePaymentEvent decodes the payment event of the received message
+func DecodePaymentEvent(m messaging.Message) (model.PaymentEvent, error) {
+	var payload model.Payment
+	if err := json.Unmarshal(m.Value, &payload); err != nil {
+		return model.PaymentEvent{}, err
+	}
+	return model.PaymentEvent{
+		GroupID:   m.Group,
+		EventID:   m.EventID(),
+		EventType: m.EventType(),
+		MsgID:     m.Key,
+		Timestamp: m.Timestamp,
+		Payload:   payload,
+		Headers:   m.Metadata(),
+	}, nil
+}
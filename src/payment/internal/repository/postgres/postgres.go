@@ -25,3 +25,14 @@ func (r Repository) Add(ctx context.Context, tx *sql.Tx, p model.Payment) error
 
 	return nil
 }
+
+// UpdateType change the type of an existing payment, returns false if the payment does not exist
+func (r Repository) UpdateType(ctx context.Context, tx *sql.Tx, p model.Payment) (bool, error) {
+	res, err := tx.ExecContext(ctx, "UPDATE payment SET type=$1 WHERE reservation_id=$2", p.Type, p.ID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,38 @@
+// Package app wires the payment service, run by the service main or within a single process with the other services
+package app
+
+import (
+	"go.example/saga/payment/internal/controller/payment"
+	"go.example/saga/payment/internal/ingester/kafka"
+	"go.example/saga/payment/internal/repository/postgres"
+	"go.example/saga/payment/pkg/model"
+	"go.example/saga/pkg/messaging"
+	"go.example/saga/pkg/saga"
+	store "go.example/saga/pkg/store/postgres"
+)
+
+// Topic a consumed topic and the consumer group consuming it
+type Topic struct {
+	GroupID    string
+	InboxTopic string
+}
+
+// Service the payment service controller, its ingester and the topic it consumes
+type Service struct {
+	Controller *payment.Controller
+	InboxTopic Topic
+	Ingester   *messaging.Ingester[model.PaymentEvent]
+}
+
+// New wires the payment service, the events are consumed through the subscriber (e.g. kafka or the in-memory bus):
+// the payment requests of the orchestrator, or the hotel room booked events in choreography mode
+func New(mode saga.Mode, st *store.Store, subscriber messaging.Subscriber, paymentTopic Topic, roomBooking Topic) *Service {
+	inboxTopic := paymentTopic
+	if mode == saga.ModeChoreography {
+		inboxTopic = roomBooking
+	}
+	ingester := messaging.NewIngester(subscriber, inboxTopic.GroupID, inboxTopic.InboxTopic, kafka.DecodePaymentEvent)
+
+	ctrl := payment.New(mode, st, postgres.New(), ingester, store.NewEventLogs())
+	return &Service{Controller: ctrl, InboxTopic: inboxTopic, Ingester: ingester}
+}
@@ -15,6 +15,9 @@ type Payment struct {
 	PaymentDue   int64              `json:"paymentDue"`
 	CreditCardNO string             `json:"creditCardNo"`
 	Type         postgres.EventType `json:"type"`
+	// HotelID and RoomID are passed through by the choreography events
+	HotelID int64 `json:"hotelId"`
+	RoomID  int64 `json:"roomId"`
 }
 
 // PaymentStatus simulate the payment status
@@ -24,13 +27,18 @@ func (p Payment) PaymentStatus() PaymentStatus {
 	}
 
 	var status PaymentStatus
-	if p.Type == postgres.RequestEventType {
+	switch p.Type {
+	case postgres.RequestEventType, postgres.TryEventType:
 		if strings.HasSuffix(p.CreditCardNO, "9999") { //FIXME: demo purpose
 			status = PaymentStatusFailed
+		} else if p.Type == postgres.TryEventType {
+			status = PaymentStatusAuthorized
 		} else {
 			status = PaymentStatusRequested
 		}
-	} else {
+	case postgres.ConfirmEventType:
+		status = PaymentStatusCaptured
+	default:
 		status = PaymentStatusCancelled
 	}
 
@@ -46,6 +54,9 @@ const (
 	PaymentStatusCancelled = "CANCELLED"
 	PaymentStatusFailed    = "FAILED"
 	PaymentStatusCompleted = "COMPLETED"
+	// TCC payment statuses, the authorized amount is captured on confirm
+	PaymentStatusAuthorized = "AUTHORIZED"
+	PaymentStatusCaptured   = "CAPTURED"
 )
 
 // ToJSONMap convert status to Json format
@@ -55,8 +66,38 @@ func (status PaymentStatus) ToJSONMap() jsonmap.JSONMap {
 
 // PaymentEvent incoming payment event request
 type PaymentEvent struct {
+	GroupID   string
 	EventID   string
+	EventType string
 	MsgID     string
 	Timestamp time.Time
 	Payload   Payment
+	Headers   postgres.Headers
+}
+
+// Choreography domain event types
+const (
+	// RoomBookedEventType published by the hotel service, the payment is executed
+	RoomBookedEventType = "RoomBooked"
+	// PaymentCompletedEventType and PaymentFailedEventType published by the payment service
+	PaymentCompletedEventType = "PaymentCompleted"
+	PaymentFailedEventType    = "PaymentFailed"
+)
+
+// EventType returns the choreography domain event type published for the payment status
+func (status PaymentStatus) EventType() string {
+	if status == PaymentStatusFailed {
+		return PaymentFailedEventType
+	}
+	return PaymentCompletedEventType
+}
+
+// ToEventJSONMap convert the payment and its status to the choreography event payload
+func (p Payment) ToEventJSONMap(status PaymentStatus) jsonmap.JSONMap {
+	return jsonmap.JSONMap{
+		"reservationId": p.ID,
+		"hotelId":       p.HotelID,
+		"roomId":        p.RoomID,
+		"status":        string(status),
+	}
 }
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS outboxevent;
+DROP TABLE IF EXISTS eventlog;
+DROP TABLE IF EXISTS payment;
@@ -15,6 +15,8 @@ CREATE TABLE IF NOT EXISTS eventlog
     issued_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE INDEX IF NOT EXISTS eventlog_issued_on_idx ON eventlog (issued_on);
+
 CREATE TABLE IF NOT EXISTS outboxevent
 (
     id            UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
@@ -25,5 +27,7 @@ CREATE TABLE IF NOT EXISTS outboxevent
     payload       JSONB        NOT NULL
 );
 
+CREATE INDEX IF NOT EXISTS outboxevent_timestamp_idx ON outboxevent (timestamp);
+
 ALTER TABLE outboxevent
     REPLICA IDENTITY FULL;
@@ -0,0 +1,2 @@
+ALTER TABLE outboxevent
+    DROP COLUMN IF EXISTS headers;
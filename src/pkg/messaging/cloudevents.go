@@ -0,0 +1,201 @@
+package messaging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// CloudEventsMode the content mode of the CloudEvents Kafka protocol binding
+type CloudEventsMode string
+
+// CloudEventsMode type
+const (
+	// CloudEventsBinary the event attributes are ce_ headers, the message value is the event data
+	CloudEventsBinary = "binary"
+	// CloudEventsStructured the message value is the JSON event, attributes and data
+	CloudEventsStructured = "structured"
+)
+
+const (
+	cloudEventsSpecVersion  = "1.0"
+	cloudEventsHeaderPrefix = "ce_"
+	cloudEventsContentType  = "application/cloudevents+json"
+	headerContentType       = "content-type"
+	jsonContentType         = "application/json"
+)
+
+// cloudEventsAttributes the CloudEvents context attributes, the other attributes are extensions
+var cloudEventsAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true, "subject": true, "time": true,
+	"datacontenttype": true, "dataschema": true,
+}
+
+// metadataExtensions the metadata keys by extension name, the extension names are lower case alphanumeric
+var metadataExtensions = map[string]string{
+	"traceid":       MetadataTraceID,
+	"correlationid": MetadataCorrelationID,
+	"causationid":   MetadataCausationID,
+	"schemaversion": MetadataSchemaVersion,
+	"tenant":        MetadataTenant,
+}
+
+// ToCloudEvent encodes the event message (id, eventType and metadata headers, JSON value) as a CloudEvent of the
+// content mode: the source is the producing service, the subject the message key (saga ID), the time the message
+// timestamp, and the metadata entries are extension attributes. The metadata entries named as a context attribute
+// (e.g. source) or as the event data are dropped, they would override the event attributes.
+func ToCloudEvent(m Message, mode string, source string) (Message, error) {
+	attributes := map[string]string{
+		"specversion": cloudEventsSpecVersion,
+		"id":          m.EventID(),
+		"source":      source,
+		"type":        m.EventType(),
+	}
+	if m.Key != "" {
+		attributes["subject"] = m.Key
+	}
+	if !m.Timestamp.IsZero() {
+		attributes["time"] = m.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	for k, v := range m.Metadata() {
+		name := extensionName(k)
+		if cloudEventsAttributes[name] || name == "data" || name == "database64" {
+			log.Printf("Metadata %s of event %s dropped, reserved CloudEvents attribute", k, m.EventID())
+			continue
+		}
+		attributes[name] = v
+	}
+
+	headers := make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		if k != HeaderID && k != HeaderEventType && k != HeaderMetadata {
+			headers[k] = v
+		}
+	}
+
+	switch mode {
+	case CloudEventsBinary:
+		for k, v := range attributes {
+			headers[cloudEventsHeaderPrefix+k] = v
+		}
+		headers[headerContentType] = jsonContentType
+	case CloudEventsStructured:
+		event := make(map[string]interface{}, len(attributes)+2)
+		for k, v := range attributes {
+			event[k] = v
+		}
+		event["datacontenttype"] = jsonContentType
+		event["data"] = json.RawMessage(m.Value)
+
+		value, err := json.Marshal(event)
+		if err != nil {
+			return m, err
+		}
+		m.Value = value
+		headers[headerContentType] = cloudEventsContentType
+	default:
+		return m, fmt.Errorf("unknown CloudEvents mode %q, expected binary or structured", mode)
+	}
+
+	m.Headers = headers
+	return m, nil
+}
+
+// FromCloudEvent decodes the CloudEvent of the message, binary or structured, into the event message: id, eventType
+// and metadata headers (the extension attributes), the event data as value. Other messages are returned unchanged.
+func FromCloudEvent(m Message) (Message, error) {
+	attributes := map[string]string{}
+	headers := make(map[string]string, len(m.Headers))
+	switch {
+	case m.Headers[cloudEventsHeaderPrefix+"specversion"] != "":
+		for k, v := range m.Headers {
+			if strings.HasPrefix(k, cloudEventsHeaderPrefix) {
+				attributes[strings.TrimPrefix(k, cloudEventsHeaderPrefix)] = v
+			} else if k != headerContentType {
+				headers[k] = v
+			}
+		}
+	case strings.HasPrefix(m.Headers[headerContentType], cloudEventsContentType):
+		var event map[string]json.RawMessage
+		if err := json.Unmarshal(m.Value, &event); err != nil {
+			return m, fmt.Errorf("unmarshal CloudEvent: %w", err)
+		}
+
+		m.Value = nil
+		for k, raw := range event {
+			var v string
+			isString := json.Unmarshal(raw, &v) == nil
+			switch {
+			case k == "data":
+				m.Value = raw
+			case k == "data_base64" && isString:
+				data, err := base64.StdEncoding.DecodeString(v)
+				if err != nil {
+					return m, fmt.Errorf("decode CloudEvent data: %w", err)
+				}
+				m.Value = data
+			case isString:
+				attributes[k] = v
+			default:
+				attributes[k] = string(raw)
+			}
+		}
+		for k, v := range m.Headers {
+			if k != headerContentType {
+				headers[k] = v
+			}
+		}
+	default:
+		return m, nil
+	}
+
+	if attributes["specversion"] != cloudEventsSpecVersion {
+		return m, fmt.Errorf("unsupported CloudEvents spec version %q", attributes["specversion"])
+	}
+
+	metadata := map[string]string{}
+	for k, v := range attributes {
+		if cloudEventsAttributes[k] {
+			continue
+		}
+		if key, ok := metadataExtensions[k]; ok {
+			k = key
+		}
+		metadata[k] = v
+	}
+	if len(metadata) > 0 {
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			return m, err
+		}
+		headers[HeaderMetadata] = string(encoded)
+	}
+	headers[HeaderID] = attributes["id"]
+	headers[HeaderEventType] = attributes["type"]
+
+	if m.Key == "" {
+		m.Key = attributes["subject"]
+	}
+	if t, err := time.Parse(time.RFC3339Nano, attributes["time"]); err == nil {
+		m.Timestamp = t
+	}
+	m.Headers = headers
+	return m, nil
+}
+
+// extensionName the extension attribute name of the metadata key, lower case alphanumeric
+func extensionName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return -1
+		}
+	}, key)
+}
@@ -0,0 +1,62 @@
+package messaging_test
+
+import (
+	"encoding/json"
+	"go.example/saga/pkg/messaging"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCloudEventRoundTripDropsTheMetadataNamedAsAttributes(t *testing.T) {
+	metadata, _ := json.Marshal(map[string]string{
+		messaging.MetadataTraceID:       "trace-1",
+		messaging.MetadataCorrelationID: "saga-1",
+		"source":                        "/spoofed",
+		"ID":                            "spoofed-id",
+		"type":                          "SPOOFED",
+		"specversion":                   "0.3",
+		"data":                          "spoofed",
+	})
+	m := messaging.Message{
+		Topic: "payment.outbox.events",
+		Key:   "saga-1",
+		Value: []byte(`{"status":"BOOKED"}`),
+		Headers: map[string]string{
+			messaging.HeaderID:        "event-1",
+			messaging.HeaderEventType: "RoomBooked",
+			messaging.HeaderMetadata:  string(metadata),
+		},
+		Timestamp: time.Date(2023, 12, 16, 10, 0, 0, 0, time.UTC),
+	}
+
+	for _, mode := range []string{messaging.CloudEventsBinary, messaging.CloudEventsStructured} {
+		t.Run(mode, func(t *testing.T) {
+			ce, err := messaging.ToCloudEvent(m, mode, "/hotel-service")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode == messaging.CloudEventsBinary && ce.Headers["ce_source"] != "/hotel-service" {
+				t.Errorf("ce_source %q, want /hotel-service", ce.Headers["ce_source"])
+			}
+
+			got, err := messaging.FromCloudEvent(ce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.EventID() != "event-1" || got.EventType() != "RoomBooked" || got.Key != "saga-1" {
+				t.Errorf("event %s type %s key %s, want event-1 RoomBooked saga-1", got.EventID(), got.EventType(), got.Key)
+			}
+			if string(got.Value) != string(m.Value) {
+				t.Errorf("value %s, want %s", got.Value, m.Value)
+			}
+			if !got.Timestamp.Equal(m.Timestamp) {
+				t.Errorf("timestamp %s, want %s", got.Timestamp, m.Timestamp)
+			}
+			want := map[string]string{messaging.MetadataTraceID: "trace-1", messaging.MetadataCorrelationID: "saga-1"}
+			if !reflect.DeepEqual(got.Metadata(), want) {
+				t.Errorf("metadata %v, want %v", got.Metadata(), want)
+			}
+		})
+	}
+}
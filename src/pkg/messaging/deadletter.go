@@ -0,0 +1,141 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DeadLetterSuffix the suffix of the dead letter topic of a topic
+const DeadLetterSuffix = ".dlq"
+
+// Headers of the dead letters, describing the failure of the original message
+const (
+	HeaderDeadLetterError     = "dlq.error"
+	HeaderDeadLetterTopic     = "dlq.topic"
+	HeaderDeadLetterPartition = "dlq.partition"
+	HeaderDeadLetterOffset    = "dlq.offset"
+	HeaderDeadLetterGroup     = "dlq.group"
+	HeaderDeadLetterAttempts  = "dlq.attempts"
+	HeaderDeadLetterFailedOn  = "dlq.failedOn"
+)
+
+// ErrPermanent marks the failures a redelivery cannot fix, e.g. a message that cannot be decoded,
+// the subscribers drop such messages instead of redelivering them
+var ErrPermanent = errors.New("permanent failure")
+
+// Permanent wraps the error as a permanent failure
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %v", ErrPermanent, err)
+}
+
+// DeadLetterSubscriber publishes the messages whose handling failed permanently, or MaxAttempts times, to the
+// dead letter topic of their topic (<topic>.dlq) with the failure headers, the messages are then acknowledged
+type DeadLetterSubscriber struct {
+	subscriber  Subscriber
+	publisher   Publisher
+	maxAttempts int
+}
+
+// NewDeadLetterSubscriber constructor
+func NewDeadLetterSubscriber(subscriber Subscriber, publisher Publisher, maxAttempts int) *DeadLetterSubscriber {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	return &DeadLetterSubscriber{subscriber, publisher, maxAttempts}
+}
+
+// Subscribe delivers the messages of the topic to the handler, the failing ones end in the dead letter topic
+func (s *DeadLetterSubscriber) Subscribe(ctx context.Context, group string, topic string, handler Handler) error {
+	var mu sync.Mutex
+	attempts := map[string]int{}
+
+	return s.subscriber.Subscribe(ctx, group, topic, func(ctx context.Context, m Message) error {
+		err := handler(ctx, m)
+		// a retried message redelivered until due is not a failed attempt
+		if errors.Is(err, ErrNotDue) {
+			return err
+		}
+
+		key := fmt.Sprintf("%d/%d", m.Partition, m.Offset)
+		mu.Lock()
+		n := attempts[key] + 1
+		attempts[key] = n
+		if err == nil || errors.Is(err, ErrPermanent) || n >= s.maxAttempts {
+			delete(attempts, key)
+		}
+		mu.Unlock()
+
+		if err == nil || (!errors.Is(err, ErrPermanent) && n < s.maxAttempts) {
+			return err
+		}
+
+		dl := deadLetter(m, err, n)
+		log.Printf("Message %s/%d/%d failed %d times, sent to %s: %v", m.Topic, m.Partition, m.Offset, n, dl.Topic, err)
+		if err := s.publisher.Publish(ctx, dl); err != nil {
+			return fmt.Errorf("publish dead letter: %w", err)
+		}
+		return nil
+	})
+}
+
+// deadLetter the dead letter of the failed message, the original key, value and headers are kept.
+// A message failing in a retry topic is dead lettered to the dead letter topic of its original topic.
+func deadLetter(m Message, cause error, attempts int) Message {
+	topic := m.Topic
+	if t, ok := m.Headers[HeaderRetryTopic]; ok {
+		topic = t
+	}
+	if retries, err := strconv.Atoi(m.Headers[HeaderRetryAttempt]); err == nil {
+		attempts += retries
+	}
+
+	headers := make(map[string]string, len(m.Headers)+7)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	for _, h := range []string{HeaderRetryTopic, HeaderRetryGroup, HeaderRetryAttempt, HeaderRetryNotBefore, HeaderRetryError} {
+		delete(headers, h)
+	}
+	headers[HeaderID] = m.EventID()
+	headers[HeaderDeadLetterError] = cause.Error()
+	headers[HeaderDeadLetterTopic] = topic
+	headers[HeaderDeadLetterPartition] = strconv.Itoa(int(m.Partition))
+	headers[HeaderDeadLetterOffset] = strconv.FormatInt(m.Offset, 10)
+	headers[HeaderDeadLetterGroup] = m.Group
+	headers[HeaderDeadLetterAttempts] = strconv.Itoa(attempts)
+	headers[HeaderDeadLetterFailedOn] = time.Now().UTC().Format(time.RFC3339)
+
+	return Message{
+		Topic:     topic + DeadLetterSuffix,
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   headers,
+		Timestamp: m.Timestamp,
+	}
+}
+
+// Reinjected the original message of a dead letter, without the failure headers
+func Reinjected(dl Message) Message {
+	headers := make(map[string]string, len(dl.Headers))
+	for k, v := range dl.Headers {
+		headers[k] = v
+	}
+	topic := headers[HeaderDeadLetterTopic]
+	for _, h := range []string{HeaderDeadLetterError, HeaderDeadLetterTopic, HeaderDeadLetterPartition,
+		HeaderDeadLetterOffset, HeaderDeadLetterGroup, HeaderDeadLetterAttempts, HeaderDeadLetterFailedOn} {
+		delete(headers, h)
+	}
+
+	return Message{
+		Topic:     topic,
+		Key:       dl.Key,
+		Value:     dl.Value,
+		Headers:   headers,
+		Timestamp: time.Now(),
+	}
+}
@@ -0,0 +1,60 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+)
+
+// Decoder decodes the event of a received message
+type Decoder[E any] func(m Message) (E, error)
+
+// EventHandler processes a decoded event, the event is redelivered when an error is returned
+type EventHandler[E any] func(ctx context.Context, e E) error
+
+// Ingester consumes a topic within a consumer group and decodes the messages into events of type E
+type Ingester[E any] struct {
+	subscriber Subscriber
+	group      string
+	topic      string
+	decode     Decoder[E]
+}
+
+// NewIngester constructor
+func NewIngester[E any](subscriber Subscriber, group string, topic string, decode Decoder[E]) *Ingester[E] {
+	return &Ingester[E]{subscriber, group, topic, decode}
+}
+
+// Consume processes the decoded events with the handler until the context is done, a message is acknowledged
+// (its offset committed) once the handler succeeded, and redelivered when the handler fails
+func (i *Ingester[E]) Consume(ctx context.Context, handler EventHandler[E]) error {
+	return i.subscriber.Subscribe(ctx, i.group, i.topic, i.Handler(handler))
+}
+
+// Receive starts ingestion of the raw messages with the handler (e.g. storing them in the inbox)
+// until the context is done, the CloudEvents are received as event messages
+func (i *Ingester[E]) Receive(ctx context.Context, handler Handler) error {
+	return i.subscriber.Subscribe(ctx, i.group, i.topic, func(ctx context.Context, m Message) error {
+		m, err := FromCloudEvent(m)
+		if err != nil {
+			return Permanent(fmt.Errorf("message %s/%d/%d: %w", m.Topic, m.Partition, m.Offset, err))
+		}
+		return handler(ctx, m)
+	})
+}
+
+// Handler the message handler decoding the events processed by the event handler, the events are either
+// CloudEvents (binary or structured) or follow the debezium EventRouter conventions. The messages failing
+// to decode are permanent failures (dropped, or sent to the dead letter topic).
+func (i *Ingester[E]) Handler(handler EventHandler[E]) Handler {
+	return func(ctx context.Context, m Message) error {
+		m, err := FromCloudEvent(m)
+		if err != nil {
+			return Permanent(fmt.Errorf("message %s/%d/%d: %w", m.Topic, m.Partition, m.Offset, err))
+		}
+		e, err := i.decode(m)
+		if err != nil {
+			return Permanent(fmt.Errorf("unmarshal message %s/%d/%d: %w", m.Topic, m.Partition, m.Offset, err))
+		}
+		return handler(ctx, e)
+	}
+}
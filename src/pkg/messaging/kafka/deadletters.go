@@ -0,0 +1,150 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.example/saga/pkg/messaging"
+	"io"
+	"time"
+)
+
+// metadataTimeout the timeout of the topic metadata and watermark queries
+const metadataTimeout = 10 * time.Second
+
+// DeadLetters lists the dead letters of a topic and re-injects them into their original topic
+type DeadLetters struct {
+	props     ConsumerProps
+	publisher messaging.Publisher
+}
+
+// NewDeadLetters constructor
+func NewDeadLetters(props ConsumerProps, publisher messaging.Publisher) *DeadLetters {
+	return &DeadLetters{props, publisher}
+}
+
+// List reads the dead letter topic of the topic from its beginning up to its end, no offset is committed
+func (d *DeadLetters) List(ctx context.Context, topic string) ([]messaging.Message, error) {
+	dlq := topic + messaging.DeadLetterSuffix
+	group := dlq + ".admin"
+	consumer, err := kafka.NewConsumer(d.props.configMap(group))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = consumer.Close()
+	}()
+
+	metadata, err := consumer.GetMetadata(&dlq, false, int(metadataTimeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []kafka.TopicPartition
+	ends := map[int32]int64{}
+	for _, p := range metadata.Topics[dlq].Partitions {
+		low, high, err := consumer.QueryWatermarkOffsets(dlq, p.ID, int(metadataTimeout.Milliseconds()))
+		if err != nil {
+			return nil, err
+		}
+		if high > low {
+			partitions = append(partitions, kafka.TopicPartition{Topic: &dlq, Partition: p.ID, Offset: kafka.Offset(low)})
+			ends[p.ID] = high
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	if err := consumer.Assign(partitions); err != nil {
+		return nil, err
+	}
+
+	var msgs []messaging.Message
+	for len(ends) > 0 {
+		if err := ctx.Err(); err != nil {
+			return msgs, err
+		}
+		msg, err := consumer.ReadMessage(time.Second)
+		if err != nil {
+			if timedOut(err) {
+				continue
+			}
+			return msgs, err
+		}
+
+		msgs = append(msgs, message(group, msg))
+		if int64(msg.TopicPartition.Offset)+1 >= ends[msg.TopicPartition.Partition] {
+			delete(ends, msg.TopicPartition.Partition)
+		}
+	}
+	return msgs, nil
+}
+
+// Replay re-injects the dead letters at the partition/offset positions into their original topic,
+// all of them when none given. The dead letter topic is left untouched, replaying twice re-injects twice:
+// the consumers deduplicate the events.
+func (d *DeadLetters) Replay(ctx context.Context, topic string, positions ...string) (int, error) {
+	msgs, err := d.List(ctx, topic)
+	if err != nil {
+		return 0, err
+	}
+
+	selected := make(map[string]bool, len(positions))
+	for _, p := range positions {
+		selected[p] = true
+	}
+
+	var replayed []messaging.Message
+	for _, m := range msgs {
+		if len(positions) == 0 || selected[position(m)] {
+			replayed = append(replayed, messaging.Reinjected(m))
+		}
+	}
+	if len(replayed) == 0 {
+		return 0, nil
+	}
+	if err := d.publisher.Publish(ctx, replayed...); err != nil {
+		return 0, err
+	}
+	return len(replayed), nil
+}
+
+// Run executes the dead letter command: list <topic> | replay <topic> all|<partition>:<offset>...
+func (d *DeadLetters) Run(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) < 2 {
+		return errors.New("usage: dlq list <topic>|replay <topic> all|replay <topic> <partition>:<offset>...")
+	}
+
+	switch args[0] {
+	case "list":
+		msgs, err := d.List(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				position(m), m.EventID(), m.EventType(), m.Key, m.Headers[messaging.HeaderDeadLetterGroup],
+				m.Headers[messaging.HeaderDeadLetterAttempts], m.Headers[messaging.HeaderDeadLetterError])
+		}
+		return nil
+	case "replay":
+		if len(args) < 3 {
+			return errors.New("usage: dlq replay <topic> all|<partition>:<offset>...")
+		}
+		var positions []string
+		if args[2] != "all" {
+			positions = args[2:]
+		}
+		n, err := d.Replay(ctx, args[1], positions...)
+		_, _ = fmt.Fprintf(w, "%d messages replayed\n", n)
+		return err
+	default:
+		return fmt.Errorf("unknown dlq command %q, expected list or replay", args[0])
+	}
+}
+
+// position the partition:offset of the dead letter in the dead letter topic
+func position(m messaging.Message) string {
+	return fmt.Sprintf("%d:%d", m.Partition, m.Offset)
+}
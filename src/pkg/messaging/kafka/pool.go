@@ -0,0 +1,221 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.example/saga/pkg/messaging"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// pollTimeout the poll timeout of the consumers, the context and the offsets of the handled messages are checked in between
+const pollTimeout = 100 * time.Millisecond
+
+// workerQueueSize the messages queued per worker before the consumer waits for the worker
+const workerQueueSize = 16
+
+// consumeConcurrently dispatches the messages to the workers by key: the messages sharing a key (saga ID) are handled
+// in order by the same worker, the others concurrently. A message whose handling failed is redelivered by its worker,
+// blocking its key shard only. The offset of a partition is committed up to the first message not handled yet, the
+// offsets of the revoked partitions are committed and no longer tracked on rebalance.
+func (s *Subscriber) consumeConcurrently(ctx context.Context, consumer *kafka.Consumer, group string, topic string, handler messaging.Handler) error {
+	p := newPool(ctx, s.props.Workers, group, handler)
+	if err := consumer.SubscribeTopics([]string{topic}, p.rebalanced); err != nil {
+		p.close()
+		return err
+	}
+
+	var backoff consumerBackoff
+	for ctx.Err() == nil {
+		commit(consumer, p.offsets)
+
+		msg, err := consumer.ReadMessage(pollTimeout)
+		if err != nil {
+			// the poll timing out without message is not a consumer error
+			if timedOut(err) {
+				backoff.reset()
+			} else {
+				backoff.wait(ctx, err)
+			}
+			continue
+		}
+		backoff.reset()
+		p.dispatch(ctx, msg)
+	}
+
+	p.close()
+	commit(consumer, p.offsets)
+	return nil
+}
+
+// pool the workers of a subscription, a worker per key shard
+type pool struct {
+	shards  []chan *kafka.Message
+	offsets *offsets
+	wg      sync.WaitGroup
+}
+
+// newPool starts the workers handling the dispatched messages until the pool is closed
+func newPool(ctx context.Context, workers int, group string, handler messaging.Handler) *pool {
+	p := &pool{shards: make([]chan *kafka.Message, workers), offsets: newOffsets()}
+	for i := range p.shards {
+		p.shards[i] = make(chan *kafka.Message, workerQueueSize)
+		p.wg.Add(1)
+		go func(msgs <-chan *kafka.Message) {
+			defer p.wg.Done()
+			for msg := range msgs {
+				if handle(ctx, group, msg, handler) {
+					p.offsets.done(msg.TopicPartition)
+				}
+			}
+		}(p.shards[i])
+	}
+	return p
+}
+
+// dispatch queues the message to the worker of its key, the consumer waits while the worker queue is full
+func (p *pool) dispatch(ctx context.Context, msg *kafka.Message) {
+	p.offsets.add(msg.TopicPartition)
+	select {
+	case <-ctx.Done():
+	case p.shards[shard(msg.Key, len(p.shards))] <- msg:
+	}
+}
+
+// close waits for the workers to handle the queued messages
+func (p *pool) close() {
+	for _, msgs := range p.shards {
+		close(msgs)
+	}
+	p.wg.Wait()
+}
+
+// rebalanced the rebalance callback of the consumer: the handled offsets are committed before the partitions are
+// revoked, the messages of a revoked partition still in flight are redelivered to its next owner
+func (p *pool) rebalanced(consumer *kafka.Consumer, e kafka.Event) error {
+	if revoked, ok := e.(kafka.RevokedPartitions); ok {
+		commit(consumer, p.offsets)
+		p.offsets.revoke(revoked.Partitions)
+	}
+	return nil
+}
+
+// handle handles the message until it succeeds, the permanent failures are dropped. Once the context is done the
+// message in flight is handled to completion, the queued ones and the redeliveries are skipped.
+func handle(ctx context.Context, group string, msg *kafka.Message, handler messaging.Handler) bool {
+	for ctx.Err() == nil {
+		err := handler(context.WithoutCancel(ctx), message(group, msg))
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, messaging.ErrPermanent) {
+			log.Printf("Failed to handle message %v, dropped: %v", msg.TopicPartition, err)
+			return true
+		}
+
+		log.Printf("Failed to handle message %v, redelivering: %v", msg.TopicPartition, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(redeliveryDelay):
+		}
+	}
+	return false
+}
+
+// commit commits the offsets of the partitions whose handled messages progressed
+func commit(consumer *kafka.Consumer, tracker *offsets) {
+	partitions := tracker.committable()
+	if len(partitions) == 0 {
+		return
+	}
+	// offsets whose commit failed are redelivered after a rebalance, the consumers deduplicate the events
+	if _, err := consumer.CommitOffsets(partitions); err != nil {
+		log.Printf("Failed to commit offsets %v: %v", partitions, err)
+	}
+}
+
+// shard the worker of the key
+func shard(key []byte, workers int) int {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return int(h.Sum32() % uint32(workers))
+}
+
+// offsets tracks the offsets of the messages in flight per partition
+type offsets struct {
+	mu         sync.Mutex
+	partitions map[int32]*partitionOffsets
+}
+
+// partitionOffsets the offsets in flight of a partition, in dispatch order, and the next offset to commit
+type partitionOffsets struct {
+	topic    *string
+	inflight []kafka.Offset
+	done     map[kafka.Offset]bool
+	commit   kafka.Offset
+	dirty    bool
+}
+
+func newOffsets() *offsets {
+	return &offsets{partitions: map[int32]*partitionOffsets{}}
+}
+
+// add records the message dispatched to a worker
+func (o *offsets) add(tp kafka.TopicPartition) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p, ok := o.partitions[tp.Partition]
+	if !ok {
+		p = &partitionOffsets{topic: tp.Topic, done: map[kafka.Offset]bool{}}
+		o.partitions[tp.Partition] = p
+	}
+	p.inflight = append(p.inflight, tp.Offset)
+}
+
+// done records the message handled, the partition offset advances past the handled messages at the head
+func (o *offsets) done(tp kafka.TopicPartition) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// revoked in between, or handled before the partition was revoked and assigned again
+	p, ok := o.partitions[tp.Partition]
+	if !ok || len(p.inflight) == 0 || tp.Offset < p.inflight[0] {
+		return
+	}
+	p.done[tp.Offset] = true
+	for len(p.inflight) > 0 && p.done[p.inflight[0]] {
+		delete(p.done, p.inflight[0])
+		p.commit = p.inflight[0] + 1
+		p.dirty = true
+		p.inflight = p.inflight[1:]
+	}
+}
+
+// committable the offsets to commit of the partitions which progressed since the last call
+func (o *offsets) committable() []kafka.TopicPartition {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var partitions []kafka.TopicPartition
+	for id, p := range o.partitions {
+		if p.dirty {
+			partitions = append(partitions, kafka.TopicPartition{Topic: p.topic, Partition: id, Offset: p.commit})
+			p.dirty = false
+		}
+	}
+	return partitions
+}
+
+// revoke stops tracking the partitions, a partition assigned again is tracked from its next dispatched message
+func (o *offsets) revoke(partitions []kafka.TopicPartition) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, tp := range partitions {
+		delete(o.partitions, tp.Partition)
+	}
+}
@@ -0,0 +1,143 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.example/saga/pkg/messaging"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolHandlesTheMessagesOfAKeyInOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[string][]int64{}
+	p := newPool(context.Background(), 8, "group", func(ctx context.Context, m messaging.Message) error {
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		handled[m.Key] = append(handled[m.Key], m.Offset)
+		return nil
+	})
+
+	const messages, keys = 500, 10
+	for i := 0; i < messages; i++ {
+		p.dispatch(context.Background(), testMessage(0, i, fmt.Sprintf("saga-%d", i%keys)))
+	}
+	p.close()
+
+	for key, offsets := range handled {
+		if len(offsets) != messages/keys {
+			t.Errorf("key %s handled %d messages, want %d", key, len(offsets), messages/keys)
+		}
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] <= offsets[i-1] {
+				t.Fatalf("key %s handled out of order: %v", key, offsets)
+			}
+		}
+	}
+	assertCommittable(t, p.offsets, map[int32]kafka.Offset{0: messages})
+}
+
+func TestOffsetCommittedOnlyOnceTheLowerOffsetsAreHandled(t *testing.T) {
+	o := newOffsets()
+	for i := 0; i < 4; i++ {
+		o.add(testMessage(0, i, "").TopicPartition)
+	}
+
+	o.done(testMessage(0, 1, "").TopicPartition)
+	o.done(testMessage(0, 3, "").TopicPartition)
+	assertCommittable(t, o, nil)
+
+	o.done(testMessage(0, 0, "").TopicPartition)
+	assertCommittable(t, o, map[int32]kafka.Offset{0: 2})
+
+	o.done(testMessage(0, 2, "").TopicPartition)
+	assertCommittable(t, o, map[int32]kafka.Offset{0: 4})
+	assertCommittable(t, o, nil)
+}
+
+func TestRevokedPartitionNoLongerTracked(t *testing.T) {
+	o := newOffsets()
+	o.add(testMessage(0, 0, "").TopicPartition)
+	o.add(testMessage(1, 0, "").TopicPartition)
+	o.revoke([]kafka.TopicPartition{testMessage(0, 0, "").TopicPartition})
+
+	// the message of the revoked partition handled meanwhile
+	o.done(testMessage(0, 0, "").TopicPartition)
+	o.done(testMessage(1, 0, "").TopicPartition)
+	assertCommittable(t, o, map[int32]kafka.Offset{1: 1})
+
+	// assigned again, tracked from the offset redelivered
+	o.add(testMessage(0, 0, "").TopicPartition)
+	o.add(testMessage(0, 1, "").TopicPartition)
+	o.done(testMessage(0, 1, "").TopicPartition)
+	assertCommittable(t, o, nil)
+	o.done(testMessage(0, 0, "").TopicPartition)
+	assertCommittable(t, o, map[int32]kafka.Offset{0: 2})
+}
+
+// BenchmarkPool dispatches messages of 64 keys to handlers taking 100µs (e.g. a TX round trip)
+func BenchmarkPool(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			p := newPool(context.Background(), workers, "group", func(ctx context.Context, m messaging.Message) error {
+				time.Sleep(100 * time.Microsecond)
+				return nil
+			})
+			keys := make([]string, 64)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("saga-%d", i)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.dispatch(context.Background(), testMessage(0, i, keys[i%len(keys)]))
+			}
+			p.close()
+		})
+	}
+}
+
+func testMessage(partition int32, offset int, key string) *kafka.Message {
+	topic := "topic"
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)},
+		Key:            []byte(key),
+	}
+}
+
+// assertCommittable asserts the offsets to commit per partition
+func assertCommittable(t *testing.T, o *offsets, want map[int32]kafka.Offset) {
+	t.Helper()
+	got := map[int32]kafka.Offset{}
+	for _, tp := range o.committable() {
+		got[tp.Partition] = tp.Offset
+	}
+	if len(got) != len(want) {
+		t.Fatalf("committable %v, want %v", got, want)
+	}
+	for partition, offset := range want {
+		if got[partition] != offset {
+			t.Fatalf("committable %v, want %v", got, want)
+		}
+	}
+}
+
+func TestConsumerBackoffDoublesUpToTheMax(t *testing.T) {
+	var b consumerBackoff
+	want := errorBackoff
+	for i := 0; i < 10; i++ {
+		if delay := b.next(); delay != want {
+			t.Fatalf("error %d backoff %s, want %s", i+1, delay, want)
+		}
+		want = min(want*2, errorMaxBackoff)
+	}
+
+	b.reset()
+	if delay := b.next(); delay != errorBackoff {
+		t.Errorf("backoff after reset %s, want %s", delay, errorBackoff)
+	}
+}
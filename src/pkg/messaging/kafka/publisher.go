@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.example/saga/pkg/messaging"
+)
+
+// Publisher publishes messages to Kafka with an idempotent producer
+type Publisher struct {
+	producer *kafka.Producer
+}
+
+// NewPublisher constructor
+func NewPublisher(bootstrapServers string) (*Publisher, error) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  bootstrapServers,
+		"enable.idempotence": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Publisher{producer}, nil
+}
+
+// Publish produces the messages in order and waits for their delivery
+func (p *Publisher) Publish(ctx context.Context, msgs ...messaging.Message) error {
+	deliveries := make(chan kafka.Event, len(msgs))
+	for _, m := range msgs {
+		topic := m.Topic
+		msg := &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+			Key:            []byte(m.Key),
+			Value:          m.Value,
+			Timestamp:      m.Timestamp,
+		}
+		for k, v := range m.Headers {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+		if err := p.producer.Produce(msg, deliveries); err != nil {
+			return err
+		}
+	}
+
+	for range msgs {
+		var e kafka.Event
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e = <-deliveries:
+		}
+
+		m, ok := e.(*kafka.Message)
+		if !ok {
+			return errors.New("unexpected kafka delivery report")
+		}
+		if m.TopicPartition.Error != nil {
+			return fmt.Errorf("deliver message to %s: %w", *m.TopicPartition.Topic, m.TopicPartition.Error)
+		}
+	}
+	return nil
+}
+
+// Close flushes the pending messages and closes the producer
+func (p *Publisher) Close() {
+	p.producer.Flush(5000)
+	p.producer.Close()
+}
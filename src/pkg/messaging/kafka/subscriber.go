@@ -0,0 +1,176 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.example/saga/pkg/messaging"
+	"log"
+	"time"
+)
+
+// redeliveryDelay the delay before a message whose handling failed is redelivered
+const redeliveryDelay = time.Second
+
+// errorBackoff and errorMaxBackoff the delay before polling again after a consumer error (e.g. broker unreachable),
+// doubled on each consecutive error up to errorMaxBackoff
+const (
+	errorBackoff    = 100 * time.Millisecond
+	errorMaxBackoff = 10 * time.Second
+)
+
+// ConsumerProps contain the Kafka consumer settings
+type ConsumerProps struct {
+	BootstrapServers string
+	// AutoOffsetReset where a consumer group without committed offset starts, earliest when empty
+	AutoOffsetReset string
+	// SessionTimeout and MaxPollInterval the librdkafka defaults apply when zero
+	SessionTimeout  time.Duration
+	MaxPollInterval time.Duration
+	// Config additional librdkafka settings, e.g. fetch.min.bytes
+	Config map[string]string
+	// Workers the messages handled concurrently per subscription, in order per key, one at a time when not above 1
+	Workers int
+}
+
+// configMap the librdkafka configuration of the group consumer, the offsets are committed once the messages are handled
+func (p ConsumerProps) configMap(group string) *kafka.ConfigMap {
+	cm := kafka.ConfigMap{}
+	for k, v := range p.Config {
+		cm[k] = v
+	}
+
+	cm["bootstrap.servers"] = p.BootstrapServers
+	cm["group.id"] = group
+	cm["enable.auto.commit"] = false
+	cm["auto.offset.reset"] = "earliest"
+	if p.AutoOffsetReset != "" {
+		cm["auto.offset.reset"] = p.AutoOffsetReset
+	}
+	if p.SessionTimeout > 0 {
+		cm["session.timeout.ms"] = int(p.SessionTimeout.Milliseconds())
+	}
+	if p.MaxPollInterval > 0 {
+		cm["max.poll.interval.ms"] = int(p.MaxPollInterval.Milliseconds())
+	}
+	return &cm
+}
+
+// Subscriber consumes Kafka topics, one consumer per subscription
+type Subscriber struct {
+	props ConsumerProps
+}
+
+// NewSubscriber constructor
+func NewSubscriber(props ConsumerProps) *Subscriber {
+	return &Subscriber{props}
+}
+
+// Subscribe consumes the topic within the consumer group until the context is done, the offset of a message is
+// committed once handled (e.g. the processing TX committed), a message whose handling failed is redelivered
+// unless the failure is permanent. Once the context is done the messages in flight are handled, their offsets
+// committed, and the consumer leaves the group.
+func (s *Subscriber) Subscribe(ctx context.Context, group string, topic string, handler messaging.Handler) error {
+	consumer, err := kafka.NewConsumer(s.props.configMap(group))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = consumer.Close()
+	}()
+
+	if s.props.Workers > 1 {
+		return s.consumeConcurrently(ctx, consumer, group, topic, handler)
+	}
+
+	if err := consumer.SubscribeTopics([]string{topic}, nil); err != nil {
+		return err
+	}
+
+	var backoff consumerBackoff
+	for ctx.Err() == nil {
+		msg, err := consumer.ReadMessage(pollTimeout)
+		if err != nil {
+			// the poll timing out without message is not a consumer error
+			if timedOut(err) {
+				backoff.reset()
+			} else {
+				backoff.wait(ctx, err)
+			}
+			continue
+		}
+		backoff.reset()
+
+		// the message in flight is handled to completion (e.g. its TX committed) when the context is done
+		err = handler(context.WithoutCancel(ctx), message(group, msg))
+		if errors.Is(err, messaging.ErrPermanent) {
+			log.Printf("Failed to handle message %v, dropped: %v", msg.TopicPartition, err)
+		} else if err != nil {
+			log.Printf("Failed to handle message %v, redelivering: %v", msg.TopicPartition, err)
+			if err := consumer.Seek(msg.TopicPartition, 0); err != nil {
+				log.Printf("Failed to seek %v: %v", msg.TopicPartition, err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(redeliveryDelay):
+			}
+			continue
+		}
+
+		// a message whose commit failed is redelivered after a rebalance, the consumers deduplicate the events
+		if _, err := consumer.CommitMessage(msg); err != nil {
+			log.Printf("Failed to commit offset %v: %v", msg.TopicPartition, err)
+		}
+	}
+	return nil
+}
+
+// consumerBackoff the delay before the consumer polls again after consecutive consumer errors
+type consumerBackoff struct {
+	delay time.Duration
+}
+
+// next the delay after one more consecutive error, errorBackoff doubled up to errorMaxBackoff
+func (b *consumerBackoff) next() time.Duration {
+	b.delay = min(max(b.delay*2, errorBackoff), errorMaxBackoff)
+	return b.delay
+}
+
+// wait logs the consumer error and waits the next delay or the context done
+func (b *consumerBackoff) wait(ctx context.Context, err error) {
+	delay := b.next()
+	log.Printf("Consumer error, polling again in %s: %v", delay, err)
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
+
+// reset the consumer polled without error, the next error waits errorBackoff
+func (b *consumerBackoff) reset() {
+	b.delay = 0
+}
+
+// timedOut whether the consumer poll timed out without message
+func timedOut(err error) bool {
+	var kerr kafka.Error
+	return errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut
+}
+
+// message the Kafka message received by the consumer group
+func message(group string, msg *kafka.Message) messaging.Message {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	return messaging.Message{
+		Topic:     *msg.TopicPartition.Topic,
+		Key:       string(msg.Key),
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+		Group:     group,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+	}
+}
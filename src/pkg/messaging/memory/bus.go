@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"go.example/saga/pkg/messaging"
+	"log"
+	"sync"
+	"time"
+)
+
+// Bus an in-memory message bus, e.g. wiring the services and the outbox relay within a single process.
+// Each topic is a single partition log, each consumer group consumes it from its own offset: the messages are
+// delivered in order, at least once, a message whose handling failed is redelivered after the redelivery delay
+// unless the failure is permanent.
+// The subscribers of a group share the group offset, one message is handled at a time per group.
+type Bus struct {
+	mu         sync.Mutex
+	topics     map[string][]messaging.Message
+	cursors    map[string]*cursor
+	notify     chan struct{}
+	redelivery time.Duration
+}
+
+// cursor the offset of a consumer group in a topic
+type cursor struct {
+	mu     sync.Mutex
+	offset int
+}
+
+// NewBus constructor
+func NewBus(redeliveryDelay time.Duration) *Bus {
+	return &Bus{
+		topics:     map[string][]messaging.Message{},
+		cursors:    map[string]*cursor{},
+		notify:     make(chan struct{}),
+		redelivery: redeliveryDelay,
+	}
+}
+
+// Publish appends the messages to their topic and wakes up the subscribers
+func (b *Bus) Publish(_ context.Context, msgs ...messaging.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, m := range msgs {
+		headers := make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			headers[k] = v
+		}
+		m.Headers = headers
+		if m.Timestamp.IsZero() {
+			m.Timestamp = time.Now()
+		}
+		m.Offset = int64(len(b.topics[m.Topic]))
+		b.topics[m.Topic] = append(b.topics[m.Topic], m)
+	}
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+	return nil
+}
+
+// Subscribe delivers the messages of the topic to the consumer group until the context is done
+func (b *Bus) Subscribe(ctx context.Context, group string, topic string, handler messaging.Handler) error {
+	c := b.cursor(group, topic)
+	for ctx.Err() == nil {
+		c.mu.Lock()
+		m, ok, notify := b.next(topic, c.offset)
+		if !ok {
+			c.mu.Unlock()
+			select {
+			case <-ctx.Done():
+			case <-notify:
+			}
+			continue
+		}
+
+		m.Group = group
+		// the message in flight is handled to completion when the context is done
+		err := handler(context.WithoutCancel(ctx), m)
+		if errors.Is(err, messaging.ErrPermanent) {
+			log.Printf("Failed to handle message %s/%d, dropped: %v", topic, m.Offset, err)
+		} else if err != nil {
+			c.mu.Unlock()
+			log.Printf("Failed to handle message %s/%d, redelivering: %v", topic, m.Offset, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(b.redelivery):
+			}
+			continue
+		}
+		c.offset++
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// Messages returns the messages published to the topic
+func (b *Bus) Messages(topic string) []messaging.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]messaging.Message(nil), b.topics[topic]...)
+}
+
+func (b *Bus) cursor(group string, topic string) *cursor {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := group + "/" + topic
+	c, ok := b.cursors[key]
+	if !ok {
+		c = &cursor{}
+		b.cursors[key] = c
+	}
+	return c
+}
+
+// next returns the message at the offset, or the channel notified on the next publication when none
+func (b *Bus) next(topic string, offset int) (messaging.Message, bool, chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if offset < len(b.topics[topic]) {
+		return b.topics[topic][offset], true, nil
+	}
+	return messaging.Message{}, false, b.notify
+}
@@ -0,0 +1,88 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"time"
+)
+
+// Headers of the messages published with the debezium EventRouter conventions
+const (
+	// HeaderID the event ID
+	HeaderID = "id"
+	// HeaderEventType the event type
+	HeaderEventType = "eventType"
+	// HeaderMetadata the event metadata (trace, correlation, causation IDs...) as a JSON object
+	HeaderMetadata = "headers"
+)
+
+// Keys of the event metadata
+const (
+	MetadataTraceID       = "traceId"
+	MetadataCorrelationID = "correlationId"
+	MetadataCausationID   = "causationId"
+	MetadataSchemaVersion = "schemaVersion"
+	MetadataTenant        = "tenant"
+)
+
+// fallbackEventNamespace the UUID namespace of the event IDs derived from the message coordinates
+var fallbackEventNamespace = uuid.MustParse("6f1d8a2e-4c0b-4d5e-9a57-3b8e2f1c7d90")
+
+// Message a message published to a topic, or received by a consumer group
+type Message struct {
+	Topic     string
+	Key       string
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+	// Group, Partition and Offset are set on the received messages
+	Group     string
+	Partition int32
+	Offset    int64
+}
+
+// EventID the ID of the event carried by the message, derived from the message coordinates when the id header is
+// missing so that a redelivered message keeps its ID
+func (m Message) EventID() string {
+	if id := m.Headers[HeaderID]; id != "" {
+		return id
+	}
+	return uuid.NewSHA1(fallbackEventNamespace, []byte(fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset))).String()
+}
+
+// EventType the type of the event carried by the message
+func (m Message) EventType() string {
+	return m.Headers[HeaderEventType]
+}
+
+// Metadata the event metadata of the message, nil when missing or invalid
+func (m Message) Metadata() map[string]string {
+	v, ok := m.Headers[HeaderMetadata]
+	if !ok {
+		return nil
+	}
+
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(v), &metadata); err != nil {
+		log.Println("Unmarshal headers error: " + err.Error())
+		return nil
+	}
+	return metadata
+}
+
+// Handler processes a received message, the message is redelivered when an error is returned
+type Handler func(ctx context.Context, m Message) error
+
+// Publisher publishes messages to topics, Publish returns once the messages are acknowledged
+type Publisher interface {
+	Publish(ctx context.Context, msgs ...Message) error
+}
+
+// Subscriber delivers the messages of a topic to the consumer group, at least once and in order per key.
+// Subscribe blocks until the context is done.
+type Subscriber interface {
+	Subscribe(ctx context.Context, group string, topic string, handler Handler) error
+}
@@ -0,0 +1,159 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of the retried messages
+const (
+	HeaderRetryTopic     = "retry.topic"
+	HeaderRetryGroup     = "retry.group"
+	HeaderRetryAttempt   = "retry.attempt"
+	HeaderRetryNotBefore = "retry.notBefore"
+	HeaderRetryError     = "retry.error"
+)
+
+// defaultMaxRetryWait the longest a retried message is held by a delivery when no max wait is set,
+// below the Kafka consumer max poll interval default (5m)
+const defaultMaxRetryWait = 30 * time.Second
+
+// ErrNotDue the retried message is not due yet, the subscriber redelivers it (e.g. seeks back and polls it again)
+var ErrNotDue = errors.New("retried message not due")
+
+// RetryTopic the retry topic of the topic for the delay, e.g. payment.outbox.events.retry.5s
+func RetryTopic(topic string, delay time.Duration) string {
+	d := delay.String()
+	if strings.HasSuffix(d, "m0s") {
+		d = strings.TrimSuffix(d, "0s")
+	}
+	if strings.HasSuffix(d, "h0m") {
+		d = strings.TrimSuffix(d, "0m")
+	}
+	return topic + ".retry." + d
+}
+
+// RetrySubscriber retries the failed messages without blocking their partition: a message whose handling failed is
+// republished to the retry topic of the next delay tier (<topic>.retry.<delay>), consumed by the same consumer group
+// once the delay elapsed. Once the tiers are exhausted the message is redelivered in place (and dead lettered when
+// enabled), the permanent failures are not retried.
+// A retried message is held at most maxWait by a delivery, then redelivered until due: the delays may exceed the
+// consumer max poll interval as long as maxWait is below it.
+type RetrySubscriber struct {
+	subscriber Subscriber
+	publisher  Publisher
+	maxWait    time.Duration
+	delays     []time.Duration
+}
+
+// NewRetrySubscriber constructor
+func NewRetrySubscriber(subscriber Subscriber, publisher Publisher, maxWait time.Duration, delays ...time.Duration) *RetrySubscriber {
+	if maxWait <= 0 {
+		maxWait = defaultMaxRetryWait
+	}
+	return &RetrySubscriber{subscriber, publisher, maxWait, delays}
+}
+
+// Subscribe consumes the topic and its retry topics within the consumer group until the context is done
+func (s *RetrySubscriber) Subscribe(ctx context.Context, group string, topic string, handler Handler) error {
+	if len(s.delays) == 0 {
+		return s.subscriber.Subscribe(ctx, group, topic, handler)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(s.delays)+1)
+	go func() {
+		errs <- s.subscriber.Subscribe(ctx, group, topic, s.retrying(group, topic, 0, handler))
+	}()
+	for i, delay := range s.delays {
+		go func(tier int, retryTopic string) {
+			errs <- s.subscriber.Subscribe(ctx, group, retryTopic, s.delayed(ctx, group, topic, tier, handler))
+		}(i+1, RetryTopic(topic, delay))
+	}
+
+	var err error
+	for i := 0; i < cap(errs); i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+			cancel()
+		}
+	}
+	return err
+}
+
+// retrying the handler republishing the failed messages of the tier to the retry topic of the next tier
+func (s *RetrySubscriber) retrying(group string, topic string, tier int, handler Handler) Handler {
+	return func(ctx context.Context, m Message) error {
+		err := handler(ctx, m)
+		if err == nil || errors.Is(err, ErrPermanent) || tier >= len(s.delays) {
+			return err
+		}
+
+		r := retried(m, group, topic, tier+1, s.delays[tier], err)
+		if err := s.publisher.Publish(ctx, r); err != nil {
+			return fmt.Errorf("publish retry: %w", err)
+		}
+		log.Printf("Message %s/%d/%d failed, retried in %s through %s: %v", m.Topic, m.Partition, m.Offset, s.delays[tier], r.Topic, err)
+		return nil
+	}
+}
+
+// delayed the handler of a retry topic, the messages are handled once their delay elapsed. The messages of a
+// retry topic share the same delay: waiting for the first one doesn't delay the next ones further. A message not
+// due within maxWait, or still waiting when the subscription is done, is redelivered.
+func (s *RetrySubscriber) delayed(subscription context.Context, group string, topic string, tier int, handler Handler) Handler {
+	h := s.retrying(group, topic, tier, handler)
+	return func(ctx context.Context, m Message) error {
+		// retried for another consumer group of the topic
+		if m.Headers[HeaderRetryGroup] != group {
+			return nil
+		}
+
+		if notBefore, err := time.Parse(time.RFC3339Nano, m.Headers[HeaderRetryNotBefore]); err == nil {
+			wait := time.Until(notBefore)
+			if wait > s.maxWait {
+				wait = s.maxWait
+			}
+			select {
+			case <-subscription.Done():
+				return subscription.Err()
+			case <-time.After(wait):
+			}
+			if time.Now().Before(notBefore) {
+				return fmt.Errorf("%w before %s", ErrNotDue, notBefore.Format(time.RFC3339))
+			}
+		}
+
+		m.Topic = topic
+		return h(ctx, m)
+	}
+}
+
+// retried the message republished to the retry topic of the tier, the event ID is kept
+func retried(m Message, group string, topic string, attempt int, delay time.Duration, cause error) Message {
+	headers := make(map[string]string, len(m.Headers)+6)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[HeaderID] = m.EventID()
+	headers[HeaderRetryTopic] = topic
+	headers[HeaderRetryGroup] = group
+	headers[HeaderRetryAttempt] = strconv.Itoa(attempt)
+	headers[HeaderRetryNotBefore] = time.Now().Add(delay).UTC().Format(time.RFC3339Nano)
+	headers[HeaderRetryError] = cause.Error()
+
+	return Message{
+		Topic:     RetryTopic(topic, delay),
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   headers,
+		Timestamp: m.Timestamp,
+	}
+}
@@ -0,0 +1,79 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"go.example/saga/pkg/messaging"
+	"go.example/saga/pkg/messaging/memory"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRetryDelayLongerThanMaxWaitIsRedeliveredUntilDue the delayed retry is redelivered while not due instead of
+// holding the delivery for the whole delay
+func TestRetryDelayLongerThanMaxWaitIsRedeliveredUntilDue(t *testing.T) {
+	bus := memory.NewBus(10 * time.Millisecond)
+	delay, maxWait := 300*time.Millisecond, 50*time.Millisecond
+
+	var mu sync.Mutex
+	var attempts []time.Time
+	handled := make(chan struct{})
+	handler := func(ctx context.Context, m messaging.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 1 {
+			return errors.New("transient")
+		}
+		close(handled)
+		return nil
+	}
+
+	notDue := 0
+	subscriber := messaging.NewRetrySubscriber(subscriberFunc(func(ctx context.Context, group string, topic string, handler messaging.Handler) error {
+		return bus.Subscribe(ctx, group, topic, func(ctx context.Context, m messaging.Message) error {
+			err := handler(ctx, m)
+			if errors.Is(err, messaging.ErrNotDue) {
+				mu.Lock()
+				notDue++
+				mu.Unlock()
+			}
+			return err
+		})
+	}), bus, maxWait, delay)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- subscriber.Subscribe(ctx, "group", "topic", handler) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if err := bus.Publish(ctx, messaging.Message{Topic: "topic", Key: "key", Value: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retried message not handled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if elapsed := attempts[1].Sub(attempts[0]); elapsed < delay {
+		t.Errorf("retried after %s, want at least %s", elapsed, delay)
+	}
+	if notDue < 2 {
+		t.Errorf("%d not due redeliveries, want the delay split in waits of at most %s", notDue, maxWait)
+	}
+}
+
+// subscriberFunc adapts a function to messaging.Subscriber
+type subscriberFunc func(ctx context.Context, group string, topic string, handler messaging.Handler) error
+
+func (f subscriberFunc) Subscribe(ctx context.Context, group string, topic string, handler messaging.Handler) error {
+	return f(ctx, group, topic, handler)
+}
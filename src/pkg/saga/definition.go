@@ -0,0 +1,139 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go.example/saga/pkg/jsonmap"
+	"time"
+)
+
+// StepKind defines how a saga step is executed by the orchestrator
+type StepKind string
+
+// StepKind type
+const (
+	StepKindParticipant = "PARTICIPANT" // command/reply exchanged with a participant service
+	StepKindChildSaga   = "CHILD_SAGA"  // starts child sagas and completes/fails when they do
+	StepKindSignal      = "SIGNAL"      // parks the saga until an external signal (e.g. human approval) arrives
+)
+
+// Protocol defines the coordination protocol of the saga steps
+type Protocol string
+
+// Protocol type
+const (
+	// ProtocolSaga steps are requested one by one and the succeeded ones are compensated in reverse order on failure
+	ProtocolSaga = "SAGA"
+	// ProtocolTCC steps are tried one by one (tentative reservation), then all of them are confirmed
+	// once every try succeeded, or the tried ones are cancelled otherwise
+	ProtocolTCC = "TCC"
+)
+
+// LockPolicy defines how a saga behaves when the semantic lock of a step is held by another saga
+type LockPolicy string
+
+// LockPolicy type
+const (
+	// LockPolicyFailFast the step fails and the saga is compensated
+	LockPolicyFailFast = "FAIL_FAST"
+	// LockPolicyWait the saga waits for the lock up to the step Timeout, then the step fails
+	LockPolicyWait = "WAIT"
+	// LockPolicyQueue the saga waits for the lock without timeout, waiting sagas get the lock in FIFO order
+	LockPolicyQueue = "QUEUE"
+)
+
+// Step defines a saga step and the way it is executed
+type Step struct {
+	Name SagaStep
+	Kind StepKind
+
+	// ChildSaga the saga type started by a StepKindChildSaga step
+	ChildSaga string
+	// Children splits the parent payload into one payload per child saga
+	Children func(payload jsonmap.JSONMap) []jsonmap.JSONMap
+
+	// Signal the signal name awaited by a StepKindSignal step, the step name is used when empty
+	Signal string
+	// Timeout fails the StepKindSignal step when the signal does not arrive in time,
+	// or the LockPolicyWait step when the lock is not acquired in time, no timeout when zero
+	Timeout time.Duration
+
+	// Lock names the semantic lock (e.g. room:3:2023-12-16) acquired by the saga before executing the step,
+	// the lock is held until the saga completes or aborts
+	Lock func(payload jsonmap.JSONMap) string
+	// LockPolicy applies when the lock is held by another saga
+	LockPolicy LockPolicy
+}
+
+// SignalName returns the signal name awaited by the step
+func (s Step) SignalName() string {
+	if s.Signal == "" {
+		return string(s.Name)
+	}
+	return s.Signal
+}
+
+// Limit caps the active (started, not yet completed/aborted) sagas of a definition type,
+// the sagas started over the limit are pending until a slot frees up
+type Limit struct {
+	// Key the payload key (e.g. hotelId) grouping the sagas limited together, the limit applies to the whole saga type when empty
+	Key string
+	// Max the active sagas allowed
+	Max int
+}
+
+// Definition defines a saga type and its steps in order to follow
+type Definition struct {
+	Type     string
+	Protocol Protocol
+	Steps    []Step
+	Limits   []Limit
+	// Ended is invoked within the TX once a saga of the type completes or aborts (e.g. updating the saga aggregate),
+	// including the sagas ending as a side effect: child sagas aborted at start, pending sagas begun once granted a slot
+	Ended func(ctx context.Context, tx *sql.Tx, state SagaState) error
+}
+
+// limitNames names the concurrency limits applying to the saga payload with their max active sagas
+func (d Definition) limitNames(payload jsonmap.JSONMap) map[string]int {
+	if len(d.Limits) == 0 {
+		return nil
+	}
+
+	names := make(map[string]int, len(d.Limits))
+	for _, l := range d.Limits {
+		if l.Key == "" {
+			names[d.Type] = l.Max
+			continue
+		}
+		names[fmt.Sprintf("%s:%s=%v", d.Type, l.Key, payload[l.Key])] = l.Max
+	}
+	return names
+}
+
+// StepNames returns the saga steps names in order
+func (d Definition) StepNames() []SagaStep {
+	steps := make([]SagaStep, 0, len(d.Steps))
+	for _, s := range d.Steps {
+		steps = append(steps, s.Name)
+	}
+	return steps
+}
+
+// Step finds the step definition by name, participant step is assumed for unknown steps
+func (d Definition) Step(name SagaStep) Step {
+	for _, s := range d.Steps {
+		if s.Name == name {
+			return s
+		}
+	}
+	return Step{Name: name, Kind: StepKindParticipant}
+}
+
+// LastStep returns the last step of the saga
+func (d Definition) LastStep() SagaStep {
+	if len(d.Steps) == 0 {
+		return ""
+	}
+	return d.Steps[len(d.Steps)-1].Name
+}
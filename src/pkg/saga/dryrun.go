@@ -0,0 +1,115 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go.example/saga/pkg/jsonmap"
+)
+
+// maxDryRunEvents bounds the dry run of a saga definition replying forever (e.g. a cycle of child sagas)
+const maxDryRunEvents = 1000
+
+// Script scripts the participant replies of a dry run by step name (e.g. "payment") or by step and command
+// (e.g. "payment/CANCEL"), the signal steps are approved with SagaStepStatusSucceeded and denied otherwise.
+// The cancel and confirm commands are replied COMPENSATED and CONFIRMED unless scripted,
+// the saga waits on the steps without scripted reply.
+type Script map[string]SagaStepStatus
+
+// reply finds the scripted reply of the step command
+func (s Script) reply(step SagaStep, cmd Command) (SagaStepStatus, bool) {
+	if status, ok := s[fmt.Sprintf("%s/%s", step, cmd)]; ok {
+		return status, true
+	}
+
+	switch cmd {
+	case CommandCancel:
+		return SagaStepStatusCompensated, true
+	case CommandConfirm:
+		return SagaStepStatusConfirmed, true
+	}
+
+	status, ok := s[string(step)]
+	return status, ok
+}
+
+// PlannedEvent a saga command published by a dry run, read back from the outbox
+type PlannedEvent struct {
+	ID      string
+	SagaID  string
+	Step    SagaStep
+	Command Command
+}
+
+// Plan the outcome of a saga dry run
+type Plan struct {
+	SagaID      string          `json:"sagaId"`
+	Type        string          `json:"type"`
+	SagaStatus  SagaStatus      `json:"sagaStatus"`
+	CurrentStep SagaStep        `json:"currentStep"`
+	StepStatus  jsonmap.JSONMap `json:"stepStatus"`
+}
+
+// DryRun runs the started saga against the scripted participant replies within the TX: the commands published are
+// read back with published and replied, the saga parked on a signal step receives the scripted signal. The caller
+// rolls the TX back (e.g. postgres.TxOptions.DryRun), the saga runs as a started one would, against the semantic
+// locks and concurrency slots held.
+func (o *Orchestrator) DryRun(ctx context.Context, tx *sql.Tx, sagaID string, script Script, published func() ([]PlannedEvent, error)) (*Plan, error) {
+	replied := map[string]bool{}
+	for progress := true; progress; {
+		progress = false
+
+		events, err := published()
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > maxDryRunEvents {
+			return nil, fmt.Errorf("saga %s dry run exceeded %d events", sagaID, maxDryRunEvents)
+		}
+
+		for _, e := range events {
+			if replied[e.ID] {
+				continue
+			}
+			replied[e.ID] = true
+
+			status, ok := script.reply(e.Step, e.Command)
+			if !ok {
+				continue
+			}
+			if _, err := o.OnStepEvent(ctx, tx, e.SagaID, e.Step, status); err != nil {
+				return nil, err
+			}
+			progress = true
+		}
+
+		// the saga parked on a signal step receives the scripted signal
+		state, err := o.repository.QueryByID(ctx, tx, sagaID)
+		if err != nil {
+			return nil, err
+		}
+		step := o.definitions[state.Type].Step(state.CurrentStep)
+		status, ok := script[string(step.Name)]
+		if step.Kind != StepKindSignal || state.CurrentStepStatus() != SagaStepStatusStarted || !ok {
+			continue
+		}
+
+		signal := Signal{Name: step.SignalName(), Approved: status == SagaStepStatusSucceeded}
+		if _, err := o.Signal(ctx, tx, sagaID, signal); err != nil {
+			return nil, err
+		}
+		progress = true
+	}
+
+	final, err := o.repository.QueryByID(ctx, tx, sagaID)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{
+		SagaID:      final.ID.String(),
+		Type:        final.Type,
+		SagaStatus:  final.SagaStatus,
+		CurrentStep: final.CurrentStep,
+		StepStatus:  final.StepStatus,
+	}, nil
+}
@@ -0,0 +1,12 @@
+package saga
+
+// Mode defines how the saga participants are coordinated
+type Mode string
+
+// Mode type
+const (
+	// ModeOrchestration the reservation service orchestrates the participants through commands and replies
+	ModeOrchestration = "orchestration"
+	// ModeChoreography each participant reacts to the domain events published by the others
+	ModeChoreography = "choreography"
+)
@@ -0,0 +1,644 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.example/saga/pkg/jsonmap"
+	"log"
+	"time"
+)
+
+var (
+	// ErrUnknownSagaType is returned when no definition is registered for a saga type
+	ErrUnknownSagaType = errors.New("unknown saga type")
+	// ErrUnexpectedSignal is returned when the saga is not awaiting the provided signal
+	ErrUnexpectedSignal = errors.New("saga is not awaiting the signal")
+)
+
+// Signal defines an external event (e.g. a manager approval) awaited by a StepKindSignal step
+type Signal struct {
+	Name     string
+	Approved bool
+	Data     jsonmap.JSONMap
+}
+
+// Command defines the command sent to a saga step participant
+type Command string
+
+// Command type
+const (
+	CommandRequest = "REQUEST"
+	CommandCancel  = "CANCEL"
+	CommandTry     = "TRY"
+	CommandConfirm = "CONFIRM"
+)
+
+// Publisher publishes saga commands to the step participants as part of current tx
+type Publisher interface {
+	Publish(ctx context.Context, tx *sql.Tx, sagaID string, step SagaStep, command Command, payload jsonmap.JSONMap) error
+}
+
+// Locker manages the saga semantic locks as part of current tx
+type Locker interface {
+	// Acquire takes the named lock for the saga, or queues the saga as waiter when wait is true, returns whether acquired
+	Acquire(ctx context.Context, tx *sql.Tx, name string, sagaID string, wait bool) (bool, error)
+	// Dequeue removes the saga from the named lock waiters
+	Dequeue(ctx context.Context, tx *sql.Tx, name string, sagaID string) error
+	// Release releases all the saga locks, grants them to the oldest waiters and returns the sagas granted
+	Release(ctx context.Context, tx *sql.Tx, sagaID string) ([]string, error)
+}
+
+// Limiter manages the saga concurrency slots as part of current tx
+type Limiter interface {
+	// Acquire takes a slot of every named limit for the saga, or queues the saga as pending, returns whether acquired
+	Acquire(ctx context.Context, tx *sql.Tx, sagaID string, limits map[string]int) (bool, error)
+	// Release frees the saga slots, grants them to the oldest pending sagas and returns the sagas granted
+	Release(ctx context.Context, tx *sql.Tx, sagaID string) ([]string, error)
+}
+
+// Orchestrator drives the registered saga definitions through their steps
+type Orchestrator struct {
+	repository  Repository
+	publisher   Publisher
+	locker      Locker
+	limiter     Limiter
+	definitions map[string]Definition
+}
+
+// NewOrchestrator constructor, the locker can be nil when no step defines a semantic lock
+// and the limiter when no definition defines concurrency limits
+func NewOrchestrator(repository Repository, publisher Publisher, locker Locker, limiter Limiter, definitions ...Definition) *Orchestrator {
+	defs := make(map[string]Definition, len(definitions))
+	for _, d := range definitions {
+		defs[d.Type] = d
+	}
+	return &Orchestrator{repository, publisher, locker, limiter, defs}
+}
+
+// Start creates a saga of the provided type and executes its first step
+func (o *Orchestrator) Start(ctx context.Context, tx *sql.Tx, sagaType string, payload jsonmap.JSONMap) (*SagaState, error) {
+	return o.start(ctx, tx, nil, sagaType, payload)
+}
+
+// OnStepEvent applies the participant reply of the provided step and moves the saga to next/prev step
+func (o *Orchestrator) OnStepEvent(ctx context.Context, tx *sql.Tx, sagaID string, step SagaStep, status SagaStepStatus) (*SagaState, error) {
+	state, err := o.repository.QueryByID(ctx, tx, sagaID)
+	if err != nil {
+		return nil, err
+	}
+
+	// TCC confirm/cancel phase, all the steps reply in parallel
+	if o.definitions[state.Type].Protocol == ProtocolTCC && state.CurrentStep == "" {
+		if err := o.phaseReply(ctx, tx, state, step, status); err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+
+	// ignore replies for a step the saga already moved away from (duplicates, late replies)
+	if state.CurrentStep != step {
+		log.Printf("Saga %s ignored %s reply for step %s, current step %s", sagaID, status, step, state.CurrentStep)
+		return state, nil
+	}
+
+	if err := o.transition(ctx, tx, state, status); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Signal applies the external signal to the saga parked on the awaiting signal step,
+// an approved signal moves the saga forward, a denied one compensates it
+func (o *Orchestrator) Signal(ctx context.Context, tx *sql.Tx, sagaID string, signal Signal) (*SagaState, error) {
+	state, err := o.repository.QueryByID(ctx, tx, sagaID)
+	if err != nil {
+		return nil, err
+	}
+
+	step := o.definitions[state.Type].Step(state.CurrentStep)
+	if step.Kind != StepKindSignal || step.SignalName() != signal.Name || state.CurrentStepStatus() != SagaStepStatusStarted {
+		return nil, fmt.Errorf("%w: saga %s signal %s", ErrUnexpectedSignal, sagaID, signal.Name)
+	}
+
+	// keep the received signals within the saga payload for audit
+	signals, _ := state.Payload["signals"].(map[string]interface{})
+	if signals == nil {
+		signals = map[string]interface{}{}
+	}
+	signals[signal.Name] = map[string]interface{}{"approved": signal.Approved, "data": signal.Data}
+	state.Payload["signals"] = signals
+
+	status := SagaStepStatus(SagaStepStatusSucceeded)
+	if !signal.Approved {
+		status = SagaStepStatusFailed
+	}
+
+	if err := o.transition(ctx, tx, state, status); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// ExpireDeadlines fails the signal steps which did not receive the awaited signal before their deadline
+// and the steps waiting for a semantic lock longer than their timeout
+func (o *Orchestrator) ExpireDeadlines(ctx context.Context, tx *sql.Tx, now time.Time) ([]SagaState, error) {
+	expired, err := o.repository.QueryExpired(ctx, tx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range expired {
+		log.Printf("Saga %s step %s timed out", expired[i].ID, expired[i].CurrentStep)
+		if expired[i].CurrentStepStatus() == SagaStepStatusWaiting {
+			step := o.definitions[expired[i].Type].Step(expired[i].CurrentStep)
+			if err := o.locker.Dequeue(ctx, tx, step.Lock(expired[i].Payload), expired[i].ID.String()); err != nil {
+				return nil, err
+			}
+		}
+		if err := o.transition(ctx, tx, &expired[i], SagaStepStatusFailed); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+func (o *Orchestrator) start(ctx context.Context, tx *sql.Tx, parentID *uuid.UUID, sagaType string, payload jsonmap.JSONMap) (*SagaState, error) {
+	def, ok := o.definitions[sagaType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSagaType, sagaType)
+	}
+
+	currStep := NextSagaStep(def.StepNames(), "")
+	state := NewSaga(sagaType, payload, currStep)
+	state.ParentID = parentID
+
+	// over the concurrency limits the saga is pending, it begins once a slot frees up
+	acquired, err := o.acquireSlots(ctx, tx, def, &state)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		log.Printf("Saga %s of type %s pending, concurrency limit reached", state.ID, sagaType)
+		state.StepStatus = jsonmap.JSONMap{}
+		state.SagaStatus = SagaStatusPending
+		return &state, o.repository.Persist(ctx, tx, state)
+	}
+
+	proceed, err := o.acquire(ctx, tx, def, &state)
+	if err != nil {
+		return nil, err
+	}
+	state.NextSagaStatus()
+	state.ended(time.Now())
+
+	// the saga is persisted first, child sagas reference it
+	if err := o.repository.Persist(ctx, tx, state); err != nil {
+		return nil, err
+	}
+
+	if !proceed {
+		// aborted right away (LockPolicyFailFast), the concurrency slots are freed
+		if state.IsTerminal() {
+			if err := o.end(ctx, tx, &state); err != nil {
+				return nil, err
+			}
+		}
+		return &state, nil
+	}
+
+	// the step execution (start time, aborted child sagas) is saved once executed
+	if err := o.execute(ctx, tx, def, &state); err != nil {
+		return nil, err
+	}
+	return &state, o.save(ctx, tx, &state)
+}
+
+// transition set the current step status, moves the saga accordingly and persist it
+func (o *Orchestrator) transition(ctx context.Context, tx *sql.Tx, state *SagaState, status SagaStepStatus) error {
+	def := o.definitions[state.Type]
+	state.StepStatus[string(state.CurrentStep)] = status
+	state.Deadline = nil
+	if status == SagaStepStatusSucceeded || status == SagaStepStatusFailed {
+		state.stepEnded(state.CurrentStep, time.Now())
+	}
+
+	var err error
+	switch status {
+	case SagaStepStatusSucceeded:
+		err = o.advance(ctx, tx, def, state)
+	case SagaStepStatusFailed:
+		if def.Protocol == ProtocolTCC {
+			err = o.cancel(ctx, tx, def, state)
+		} else {
+			err = o.goBack(ctx, tx, def, state)
+		}
+	case SagaStepStatusCompensated:
+		err = o.goBack(ctx, tx, def, state)
+	case SagaStepStatusCompensating:
+		err = o.compensate(ctx, tx, def, state)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := o.save(ctx, tx, state); err != nil {
+		return err
+	}
+
+	return o.notifyParent(ctx, tx, state)
+}
+
+// advance move saga step to next step based on definition steps and current step
+func (o *Orchestrator) advance(ctx context.Context, tx *sql.Tx, def Definition, state *SagaState) error {
+	next := NextSagaStep(def.StepNames(), state.CurrentStep)
+	state.CurrentStep = next
+	if next == "" {
+		if def.Protocol == ProtocolTCC {
+			return o.confirm(ctx, tx, def, state)
+		}
+		return nil
+	}
+
+	state.StepStatus[string(next)] = SagaStepStatusStarted
+	if proceed, err := o.acquire(ctx, tx, def, state); err != nil || !proceed {
+		return err
+	}
+	return o.execute(ctx, tx, def, state)
+}
+
+// acquire takes the semantic lock of the current step, returns whether the step can be executed:
+// the step fails when the lock is held by another saga (LockPolicyFailFast) or waits for it
+func (o *Orchestrator) acquire(ctx context.Context, tx *sql.Tx, def Definition, state *SagaState) (bool, error) {
+	step := def.Step(state.CurrentStep)
+	if step.Lock == nil {
+		return true, nil
+	}
+
+	name := step.Lock(state.Payload)
+	wait := step.LockPolicy == LockPolicyWait || step.LockPolicy == LockPolicyQueue
+	acquired, err := o.locker.Acquire(ctx, tx, name, state.ID.String(), wait)
+	if err != nil || acquired {
+		return acquired, err
+	}
+
+	if !wait {
+		log.Printf("Saga %s step %s failed, lock %s held by another saga", state.ID, step.Name, name)
+		state.StepStatus[string(step.Name)] = SagaStepStatusFailed
+		return false, o.goBack(ctx, tx, def, state)
+	}
+
+	log.Printf("Saga %s step %s waiting for lock %s", state.ID, step.Name, name)
+	state.StepStatus[string(step.Name)] = SagaStepStatusWaiting
+	if step.LockPolicy == LockPolicyWait && step.Timeout > 0 {
+		deadline := time.Now().Add(step.Timeout)
+		state.Deadline = &deadline
+	}
+	return false, nil
+}
+
+// acquireSlots takes the concurrency slots of the saga, returns whether the saga can begin
+func (o *Orchestrator) acquireSlots(ctx context.Context, tx *sql.Tx, def Definition, state *SagaState) (bool, error) {
+	limits := def.limitNames(state.Payload)
+	if len(limits) == 0 {
+		return true, nil
+	}
+	return o.limiter.Acquire(ctx, tx, state.ID.String(), limits)
+}
+
+// begin starts the first step of the pending saga granted its concurrency slots
+func (o *Orchestrator) begin(ctx context.Context, tx *sql.Tx, sagaID string) error {
+	state, err := o.repository.QueryByID(ctx, tx, sagaID)
+	if err != nil {
+		return err
+	}
+	if state.SagaStatus != SagaStatusPending {
+		return nil
+	}
+
+	log.Printf("Saga %s granted concurrency slot, beginning", state.ID)
+	def := o.definitions[state.Type]
+	state.StepStatus[string(state.CurrentStep)] = SagaStepStatusStarted
+	state.SagaStatus = SagaStatusStarted
+
+	proceed, err := o.acquire(ctx, tx, def, state)
+	if err != nil {
+		return err
+	}
+	if proceed {
+		if err := o.execute(ctx, tx, def, state); err != nil {
+			return err
+		}
+	}
+
+	if err := o.save(ctx, tx, state); err != nil {
+		return err
+	}
+	return o.notifyParent(ctx, tx, state)
+}
+
+// resume executes the waiting step of the saga granted the semantic lock
+func (o *Orchestrator) resume(ctx context.Context, tx *sql.Tx, sagaID string) error {
+	state, err := o.repository.QueryByID(ctx, tx, sagaID)
+	if err != nil {
+		return err
+	}
+	if state.CurrentStepStatus() != SagaStepStatusWaiting {
+		return nil
+	}
+
+	log.Printf("Saga %s step %s granted lock, resuming", state.ID, state.CurrentStep)
+	state.StepStatus[string(state.CurrentStep)] = SagaStepStatusStarted
+	state.Deadline = nil
+	if err := o.execute(ctx, tx, o.definitions[state.Type], state); err != nil {
+		return err
+	}
+	return o.save(ctx, tx, state)
+}
+
+// goBack move saga step to prev step based on definition steps and current step and compensate it
+func (o *Orchestrator) goBack(ctx context.Context, tx *sql.Tx, def Definition, state *SagaState) error {
+	prev := PrevSagaStep(def.StepNames(), state.CurrentStep)
+	state.CurrentStep = prev
+	if prev == "" {
+		return nil
+	}
+
+	state.StepStatus[string(prev)] = SagaStepStatusCompensating
+	return o.compensate(ctx, tx, def, state)
+}
+
+// execute runs the current step: request the participant or start the child sagas
+func (o *Orchestrator) execute(ctx context.Context, tx *sql.Tx, def Definition, state *SagaState) error {
+	step := def.Step(state.CurrentStep)
+	state.stepStarted(step.Name, time.Now())
+	switch step.Kind {
+	case StepKindSignal:
+		// park the saga, it moves on Signal or once the deadline expires
+		if step.Timeout > 0 {
+			deadline := time.Now().Add(step.Timeout)
+			state.Deadline = &deadline
+		}
+		log.Printf("Saga %s step %s awaiting signal %s", state.ID, step.Name, step.SignalName())
+		return nil
+	case StepKindChildSaga:
+	default:
+		cmd := Command(CommandRequest)
+		if def.Protocol == ProtocolTCC {
+			cmd = CommandTry
+		}
+		return o.publisher.Publish(ctx, tx, state.ID.String(), step.Name, cmd, command(state.Payload, cmd))
+	}
+
+	payloads := step.Children(state.Payload)
+	if len(payloads) == 0 {
+		return fmt.Errorf("saga %s step %s has no child sagas to start", state.ID, step.Name)
+	}
+
+	aborted := false
+	for _, payload := range payloads {
+		child, err := o.start(ctx, tx, &state.ID, step.ChildSaga, payload)
+		if err != nil {
+			return err
+		}
+		log.Printf("Saga %s step %s started child saga %s", state.ID, step.Name, child.ID)
+		aborted = aborted || child.SagaStatus == SagaStatusAborted
+	}
+
+	if !aborted {
+		return nil
+	}
+
+	// an aborted sibling started as a side effect (e.g. granted a concurrency slot) may have moved the saga already
+	current, err := o.repository.QueryByID(ctx, tx, state.ID.String())
+	if err != nil {
+		return err
+	}
+	if current.Version != state.Version {
+		*state = *current
+		return nil
+	}
+
+	// a child saga aborted right away (e.g. semantic lock held), compensate the others once they complete
+	state.StepStatus[string(step.Name)] = SagaStepStatusCompensating
+	return o.compensate(ctx, tx, def, state)
+}
+
+// compensate runs the compensation of current step: cancel the participant or compensate completed child sagas
+func (o *Orchestrator) compensate(ctx context.Context, tx *sql.Tx, def Definition, state *SagaState) error {
+	step := def.Step(state.CurrentStep)
+	switch step.Kind {
+	case StepKindSignal:
+		// nothing to undo for a received signal
+		state.StepStatus[string(step.Name)] = SagaStepStatusCompensated
+		return o.goBack(ctx, tx, def, state)
+	case StepKindChildSaga:
+	default:
+		return o.publisher.Publish(ctx, tx, state.ID.String(), step.Name, CommandCancel, command(state.Payload, CommandCancel))
+	}
+
+	children, err := o.repository.QueryChildren(ctx, tx, state.ID.String())
+	if err != nil {
+		return err
+	}
+
+	pending := false
+	for i := range children {
+		switch children[i].SagaStatus {
+		case SagaStatusCompleted:
+			if err := o.compensateCompleted(ctx, tx, &children[i]); err != nil {
+				return err
+			}
+			pending = true
+		case SagaStatusPending, SagaStatusStarted, SagaStatusAborting:
+			// running and pending children are compensated once they complete
+			pending = true
+		}
+	}
+
+	if pending {
+		return nil
+	}
+
+	state.StepStatus[string(step.Name)] = SagaStepStatusCompensated
+	return o.goBack(ctx, tx, def, state)
+}
+
+// confirm starts the TCC confirm phase once every step try succeeded
+func (o *Orchestrator) confirm(ctx context.Context, tx *sql.Tx, def Definition, state *SagaState) error {
+	for _, step := range def.StepNames() {
+		state.StepStatus[string(step)] = SagaStepStatusConfirming
+		if err := o.publisher.Publish(ctx, tx, state.ID.String(), step, CommandConfirm, command(state.Payload, CommandConfirm)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancel starts the TCC cancel phase of the already tried steps once a step try failed
+func (o *Orchestrator) cancel(ctx context.Context, tx *sql.Tx, def Definition, state *SagaState) error {
+	state.CurrentStep = ""
+	for _, step := range def.StepNames() {
+		if fmt.Sprintf("%v", state.StepStatus[string(step)]) != SagaStepStatusSucceeded {
+			continue
+		}
+
+		state.StepStatus[string(step)] = SagaStepStatusCompensating
+		if err := o.publisher.Publish(ctx, tx, state.ID.String(), step, CommandCancel, command(state.Payload, CommandCancel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// phaseReply applies the confirm/cancel reply of a TCC step
+func (o *Orchestrator) phaseReply(ctx context.Context, tx *sql.Tx, state *SagaState, step SagaStep, status SagaStepStatus) error {
+	current := fmt.Sprintf("%v", state.StepStatus[string(step)])
+	if !(current == SagaStepStatusConfirming && status == SagaStepStatusConfirmed) &&
+		!(current == SagaStepStatusCompensating && status == SagaStepStatusCompensated) {
+		log.Printf("Saga %s ignored %s reply for step %s in status %s", state.ID, status, step, current)
+		return nil
+	}
+
+	state.StepStatus[string(step)] = status
+	if err := o.save(ctx, tx, state); err != nil {
+		return err
+	}
+
+	return o.notifyParent(ctx, tx, state)
+}
+
+// compensateCompleted starts the compensation of a completed saga from its last step
+func (o *Orchestrator) compensateCompleted(ctx context.Context, tx *sql.Tx, state *SagaState) error {
+	def := o.definitions[state.Type]
+	state.CurrentStep = def.LastStep()
+	state.StepStatus[string(state.CurrentStep)] = SagaStepStatusCompensating
+
+	if err := o.compensate(ctx, tx, def, state); err != nil {
+		return err
+	}
+
+	log.Printf("Saga %s compensation started by parent saga %s", state.ID, state.ParentID)
+	return o.save(ctx, tx, state)
+}
+
+// notifyParent moves the parent saga step once the provided child saga reached a final status
+func (o *Orchestrator) notifyParent(ctx context.Context, tx *sql.Tx, child *SagaState) error {
+	if child.ParentID == nil || !child.IsTerminal() {
+		return nil
+	}
+
+	parent, err := o.repository.QueryByID(ctx, tx, child.ParentID.String())
+	if err != nil {
+		return err
+	}
+
+	if o.definitions[parent.Type].Step(parent.CurrentStep).Kind != StepKindChildSaga {
+		return nil
+	}
+
+	children, err := o.repository.QueryChildren(ctx, tx, parent.ID.String())
+	if err != nil {
+		return err
+	}
+
+	completed := child.SagaStatus == SagaStatusCompleted
+	switch parent.CurrentStepStatus() {
+	case SagaStepStatusStarted:
+		if !completed {
+			// one child aborted, compensate the ones already completed
+			return o.transition(ctx, tx, parent, SagaStepStatusCompensating)
+		}
+		if allChildren(children, SagaStatusCompleted) {
+			return o.transition(ctx, tx, parent, SagaStepStatusSucceeded)
+		}
+	case SagaStepStatusCompensating:
+		if completed {
+			// late completion of a child while the parent is compensating
+			return o.compensateCompleted(ctx, tx, child)
+		}
+		if allChildren(children, SagaStatusAborted) {
+			return o.transition(ctx, tx, parent, SagaStepStatusCompensated)
+		}
+	}
+	return nil
+}
+
+// save evaluate the saga status and persist the saga state, once completed/aborted
+// the definition Ended hook runs and the semantic locks and concurrency slots are released
+func (o *Orchestrator) save(ctx context.Context, tx *sql.Tx, state *SagaState) error {
+	state.NextSagaStatus()
+	state.ended(time.Now())
+
+	// optimistic locking, the repository updates only the version read
+	state.IncrementVersion()
+
+	if err := o.repository.Update(ctx, tx, *state); err != nil {
+		return err
+	}
+
+	if !state.IsTerminal() {
+		return nil
+	}
+	return o.end(ctx, tx, state)
+}
+
+// end runs the Ended hook of the terminal saga definition, then releases the saga locks and slots
+func (o *Orchestrator) end(ctx context.Context, tx *sql.Tx, state *SagaState) error {
+	if ended := o.definitions[state.Type].Ended; ended != nil {
+		if err := ended(ctx, tx, *state); err != nil {
+			return err
+		}
+	}
+	return o.release(ctx, tx, state)
+}
+
+// release releases the semantic locks and the concurrency slots of the terminal saga,
+// the sagas granted a lock resume and the sagas granted a slot begin
+func (o *Orchestrator) release(ctx context.Context, tx *sql.Tx, state *SagaState) error {
+	if o.locker != nil {
+		granted, err := o.locker.Release(ctx, tx, state.ID.String())
+		if err != nil {
+			return err
+		}
+		for _, sagaID := range granted {
+			if err := o.resume(ctx, tx, sagaID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if o.limiter != nil {
+		granted, err := o.limiter.Release(ctx, tx, state.ID.String())
+		if err != nil {
+			return err
+		}
+		for _, sagaID := range granted {
+			if err := o.begin(ctx, tx, sagaID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func allChildren(children []SagaState, status SagaStatus) bool {
+	for _, c := range children {
+		if c.SagaStatus != status {
+			return false
+		}
+	}
+	return true
+}
+
+// command copies the saga payload and marks it with the command type expected by participants
+func command(payload jsonmap.JSONMap, cmd Command) jsonmap.JSONMap {
+	p := make(jsonmap.JSONMap, len(payload)+1)
+	for k, v := range payload {
+		p[k] = v
+	}
+	p["type"] = string(cmd)
+	return p
+}
@@ -0,0 +1,771 @@
+package saga
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.example/saga/pkg/jsonmap"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// nopPublisher discards the published commands
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(context.Context, *sql.Tx, string, SagaStep, Command, jsonmap.JSONMap) error {
+	return nil
+}
+
+// commandLog records the commands published to the step participants
+type commandLog struct {
+	sent []sentCommand
+}
+
+// sentCommand a published command, e.g. booking/REQUEST
+type sentCommand struct {
+	sagaID  string
+	command string
+}
+
+func (l *commandLog) Publish(_ context.Context, _ *sql.Tx, sagaID string, step SagaStep, cmd Command, _ jsonmap.JSONMap) error {
+	l.sent = append(l.sent, sentCommand{sagaID, fmt.Sprintf("%s/%s", step, cmd)})
+	return nil
+}
+
+// commands the commands published for the saga, in order
+func (l *commandLog) commands(sagaID string) []string {
+	var commands []string
+	for _, c := range l.sent {
+		if c.sagaID == sagaID {
+			commands = append(commands, c.command)
+		}
+	}
+	return commands
+}
+
+// grantSlots grants every concurrency slot
+type grantSlots struct{}
+
+func (grantSlots) Acquire(context.Context, *sql.Tx, string, map[string]int) (bool, error) {
+	return true, nil
+}
+
+func (grantSlots) Release(context.Context, *sql.Tx, string) ([]string, error) {
+	return nil, nil
+}
+
+// memRepository keeps the sagas in memory, the states are copied through JSON as stored by a database
+type memRepository struct {
+	sagas map[string]SagaState
+}
+
+func (m *memRepository) Persist(_ context.Context, _ *sql.Tx, ss SagaState) error {
+	c, err := copySaga(ss)
+	if err != nil {
+		return err
+	}
+	m.sagas[ss.ID.String()] = c
+	return nil
+}
+
+func (m *memRepository) Update(_ context.Context, _ *sql.Tx, ss SagaState) error {
+	if m.sagas[ss.ID.String()].Version != ss.Version-1 {
+		return fmt.Errorf("%w: saga %s version %d", ErrConcurrentUpdate, ss.ID, ss.Version-1)
+	}
+	c, err := copySaga(ss)
+	if err != nil {
+		return err
+	}
+	m.sagas[ss.ID.String()] = c
+	return nil
+}
+
+func (m *memRepository) QueryByID(_ context.Context, _ *sql.Tx, ID string) (*SagaState, error) {
+	ss, ok := m.sagas[ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c, err := copySaga(ss)
+	return &c, err
+}
+
+func (m *memRepository) QueryChildren(_ context.Context, _ *sql.Tx, parentID string) ([]SagaState, error) {
+	var children []SagaState
+	for _, id := range m.ids() {
+		ss := m.sagas[id]
+		if ss.ParentID == nil || ss.ParentID.String() != parentID {
+			continue
+		}
+		c, err := copySaga(ss)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, c)
+	}
+	return children, nil
+}
+
+func (m *memRepository) QueryExpired(_ context.Context, _ *sql.Tx, now time.Time) ([]SagaState, error) {
+	var expired []SagaState
+	for _, id := range m.ids() {
+		ss := m.sagas[id]
+		if ss.IsTerminal() || ss.Deadline == nil || ss.Deadline.After(now) {
+			continue
+		}
+		c, err := copySaga(ss)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, c)
+	}
+	return expired, nil
+}
+
+func (m *memRepository) QueryStats(context.Context, *sql.Tx) (*Stats, error) {
+	return &Stats{}, nil
+}
+
+// ids returns the saga IDs in order, as the sagastate table ordered by id
+func (m *memRepository) ids() []string {
+	ids := make([]string, 0, len(m.sagas))
+	for id := range m.sagas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// copySaga deep copies the saga state, the JSON maps are decoded as read from a JSONB column
+func copySaga(ss SagaState) (SagaState, error) {
+	c := ss
+	for _, m := range []*jsonmap.JSONMap{&c.Payload, &c.StepStatus, &c.StepTimes} {
+		b, err := json.Marshal(*m)
+		if err != nil {
+			return c, err
+		}
+		*m = nil
+		if err := json.Unmarshal(b, m); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// heldLocks grants a semantic lock to a single saga at a time, without waiters
+type heldLocks map[string]string
+
+func (l heldLocks) Acquire(_ context.Context, _ *sql.Tx, name string, sagaID string, _ bool) (bool, error) {
+	if holder, ok := l[name]; ok && holder != sagaID {
+		return false, nil
+	}
+	l[name] = sagaID
+	return true, nil
+}
+
+func (l heldLocks) Dequeue(context.Context, *sql.Tx, string, string) error {
+	return nil
+}
+
+func (l heldLocks) Release(_ context.Context, _ *sql.Tx, sagaID string) ([]string, error) {
+	for name, holder := range l {
+		if holder == sagaID {
+			delete(l, name)
+		}
+	}
+	return nil, nil
+}
+
+// queuedLocks grants a semantic lock to a single saga at a time, the waiters are granted it in FIFO order
+type queuedLocks struct {
+	holders map[string]string
+	waiters map[string][]string
+}
+
+func newQueuedLocks() *queuedLocks {
+	return &queuedLocks{holders: map[string]string{}, waiters: map[string][]string{}}
+}
+
+func (l *queuedLocks) Acquire(_ context.Context, _ *sql.Tx, name string, sagaID string, wait bool) (bool, error) {
+	if holder, ok := l.holders[name]; !ok || holder == sagaID {
+		l.holders[name] = sagaID
+		return true, nil
+	}
+	if wait {
+		l.waiters[name] = append(l.waiters[name], sagaID)
+	}
+	return false, nil
+}
+
+func (l *queuedLocks) Dequeue(_ context.Context, _ *sql.Tx, name string, sagaID string) error {
+	waiters := l.waiters[name][:0]
+	for _, w := range l.waiters[name] {
+		if w != sagaID {
+			waiters = append(waiters, w)
+		}
+	}
+	l.waiters[name] = waiters
+	return nil
+}
+
+func (l *queuedLocks) Release(_ context.Context, _ *sql.Tx, sagaID string) ([]string, error) {
+	var granted []string
+	for name, holder := range l.holders {
+		if holder != sagaID {
+			continue
+		}
+		if len(l.waiters[name]) == 0 {
+			delete(l.holders, name)
+			continue
+		}
+		l.holders[name], l.waiters[name] = l.waiters[name][0], l.waiters[name][1:]
+		granted = append(granted, l.holders[name])
+	}
+	return granted, nil
+}
+
+// oneSlot grants a single concurrency slot, the pending sagas are granted it in FIFO order
+type oneSlot struct {
+	active  string
+	pending []string
+}
+
+func (s *oneSlot) Acquire(_ context.Context, _ *sql.Tx, sagaID string, _ map[string]int) (bool, error) {
+	if s.active == "" {
+		s.active = sagaID
+		return true, nil
+	}
+	s.pending = append(s.pending, sagaID)
+	return false, nil
+}
+
+func (s *oneSlot) Release(_ context.Context, _ *sql.Tx, sagaID string) ([]string, error) {
+	if s.active != sagaID {
+		return nil, nil
+	}
+	s.active = ""
+	if len(s.pending) == 0 {
+		return nil, nil
+	}
+	s.active, s.pending = s.pending[0], s.pending[1:]
+	return []string{s.active}, nil
+}
+
+func roomStep() Step {
+	return Step{
+		Name:       "booking",
+		Kind:       StepKindParticipant,
+		Lock:       func(p jsonmap.JSONMap) string { return "room:" + p["room"].(string) },
+		LockPolicy: LockPolicyFailFast,
+	}
+}
+
+// endedRecorder records the sagas ended through the definition hook
+type endedRecorder map[string]SagaStatus
+
+func (r endedRecorder) ended(_ context.Context, _ *sql.Tx, state SagaState) error {
+	r[state.ID.String()] = state.SagaStatus
+	return nil
+}
+
+func TestChildAbortedAtStartEndsAndParentIsSaved(t *testing.T) {
+	ctx := context.Background()
+	ended := endedRecorder{}
+	repository := &memRepository{sagas: map[string]SagaState{}}
+	o := NewOrchestrator(repository, nopPublisher{}, heldLocks{}, grantSlots{},
+		Definition{Type: "room", Steps: []Step{roomStep()}, Ended: ended.ended},
+		Definition{Type: "group", Steps: []Step{{
+			Name: "rooms", Kind: StepKindChildSaga, ChildSaga: "room",
+			Children: func(jsonmap.JSONMap) []jsonmap.JSONMap {
+				return []jsonmap.JSONMap{{"room": "1"}, {"room": "1"}}
+			},
+		}}},
+	)
+
+	group, err := o.Start(ctx, nil, "group", jsonmap.JSONMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	children, _ := repository.QueryChildren(ctx, nil, group.ID.String())
+	if len(children) != 2 {
+		t.Fatalf("%d children, want 2", len(children))
+	}
+	for _, child := range children {
+		if child.SagaStatus == SagaStatusAborted && ended[child.ID.String()] != SagaStatusAborted {
+			t.Errorf("child saga %s aborted at start, not ended", child.ID)
+		}
+	}
+	if len(ended) != 1 {
+		t.Errorf("%d sagas ended, want the child aborted at start", len(ended))
+	}
+
+	saved := repository.sagas[group.ID.String()]
+	if status := saved.CurrentStepStatus(); status != SagaStepStatusCompensating {
+		t.Errorf("saved group step status %s, want %s", status, SagaStepStatusCompensating)
+	}
+	if _, ok := saved.StepTimes["rooms"]; !ok {
+		t.Error("saved group step start time missing")
+	}
+}
+
+func TestPendingSagaAbortedOnBeginEnds(t *testing.T) {
+	ctx := context.Background()
+	ended := endedRecorder{}
+	repository := &memRepository{sagas: map[string]SagaState{}}
+	o := NewOrchestrator(repository, nopPublisher{}, heldLocks{}, &oneSlot{},
+		Definition{Type: "holder", Steps: []Step{roomStep()}},
+		Definition{Type: "limited", Steps: []Step{roomStep()}, Limits: []Limit{{Max: 1}}, Ended: ended.ended},
+	)
+
+	start := func(sagaType string, room string) *SagaState {
+		state, err := o.Start(ctx, nil, sagaType, jsonmap.JSONMap{"room": room})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+	start("holder", "1")
+	running := start("limited", "2")
+	pending := start("limited", "1")
+	if pending.SagaStatus != SagaStatusPending {
+		t.Fatalf("saga status %s, want %s", pending.SagaStatus, SagaStatusPending)
+	}
+
+	// the running saga aborts, the pending one begins and fails on the room lock held
+	if _, err := o.OnStepEvent(ctx, nil, running.ID.String(), "booking", SagaStepStatusFailed); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{running.ID.String(), pending.ID.String()} {
+		if status := repository.sagas[id].SagaStatus; status != SagaStatusAborted {
+			t.Errorf("saga %s status %s, want %s", id, status, SagaStatusAborted)
+		}
+		if ended[id] != SagaStatusAborted {
+			t.Errorf("saga %s aborted, not ended", id)
+		}
+	}
+}
+
+// stepReply a participant reply to a saga step
+type stepReply struct {
+	step   SagaStep
+	status SagaStepStatus
+}
+
+// reply applies the participant reply to the saga
+func reply(t *testing.T, o *Orchestrator, sagaID string, r stepReply) {
+	t.Helper()
+	if _, err := o.OnStepEvent(context.Background(), nil, sagaID, r.step, r.status); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stepStatuses the saga step statuses as strings
+func stepStatuses(ss SagaState) map[string]string {
+	statuses := map[string]string{}
+	for step, status := range ss.StepStatus {
+		statuses[step] = fmt.Sprintf("%v", status)
+	}
+	return statuses
+}
+
+func TestChildSagaOutcomeMovesParent(t *testing.T) {
+	type childReply struct {
+		room   string
+		status SagaStepStatus
+	}
+	tests := []struct {
+		name      string
+		replies   []childReply
+		parent    SagaStatus
+		children  map[string]SagaStatus
+		cancelled []string
+	}{
+		{
+			name:     "children completed",
+			replies:  []childReply{{"1", SagaStepStatusSucceeded}, {"2", SagaStepStatusSucceeded}},
+			parent:   SagaStatusCompleted,
+			children: map[string]SagaStatus{"1": SagaStatusCompleted, "2": SagaStatusCompleted},
+		},
+		{
+			name:      "child aborted compensates the completed sibling",
+			replies:   []childReply{{"1", SagaStepStatusSucceeded}, {"2", SagaStepStatusFailed}},
+			parent:    SagaStatusAborting,
+			children:  map[string]SagaStatus{"1": SagaStatusAborting, "2": SagaStatusAborted},
+			cancelled: []string{"1"},
+		},
+		{
+			name:      "sibling compensated aborts the parent",
+			replies:   []childReply{{"1", SagaStepStatusSucceeded}, {"2", SagaStepStatusFailed}, {"1", SagaStepStatusCompensated}},
+			parent:    SagaStatusAborted,
+			children:  map[string]SagaStatus{"1": SagaStatusAborted, "2": SagaStatusAborted},
+			cancelled: []string{"1"},
+		},
+		{
+			name:      "sibling completed late is compensated",
+			replies:   []childReply{{"2", SagaStepStatusFailed}, {"1", SagaStepStatusSucceeded}},
+			parent:    SagaStatusAborting,
+			children:  map[string]SagaStatus{"1": SagaStatusAborting, "2": SagaStatusAborted},
+			cancelled: []string{"1"},
+		},
+		{
+			name:      "sibling completed late compensated aborts the parent",
+			replies:   []childReply{{"2", SagaStepStatusFailed}, {"1", SagaStepStatusSucceeded}, {"1", SagaStepStatusCompensated}},
+			parent:    SagaStatusAborted,
+			children:  map[string]SagaStatus{"1": SagaStatusAborted, "2": SagaStatusAborted},
+			cancelled: []string{"1"},
+		},
+		{
+			name:     "children aborted abort the parent",
+			replies:  []childReply{{"1", SagaStepStatusFailed}, {"2", SagaStepStatusFailed}},
+			parent:   SagaStatusAborted,
+			children: map[string]SagaStatus{"1": SagaStatusAborted, "2": SagaStatusAborted},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repository := &memRepository{sagas: map[string]SagaState{}}
+			published := &commandLog{}
+			o := NewOrchestrator(repository, published, nil, nil,
+				Definition{Type: "room", Steps: []Step{{Name: "booking", Kind: StepKindParticipant}}},
+				Definition{Type: "group", Steps: []Step{{
+					Name: "rooms", Kind: StepKindChildSaga, ChildSaga: "room",
+					Children: func(jsonmap.JSONMap) []jsonmap.JSONMap {
+						return []jsonmap.JSONMap{{"room": "1"}, {"room": "2"}}
+					},
+				}}},
+			)
+
+			group, err := o.Start(ctx, nil, "group", jsonmap.JSONMap{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			children, _ := repository.QueryChildren(ctx, nil, group.ID.String())
+			rooms := map[string]string{}
+			for _, child := range children {
+				rooms[child.Payload["room"].(string)] = child.ID.String()
+			}
+
+			for _, r := range tt.replies {
+				reply(t, o, rooms[r.room], stepReply{"booking", r.status})
+			}
+
+			if status := repository.sagas[group.ID.String()].SagaStatus; status != tt.parent {
+				t.Errorf("parent status %s, want %s", status, tt.parent)
+			}
+			for room, want := range tt.children {
+				if status := repository.sagas[rooms[room]].SagaStatus; status != want {
+					t.Errorf("room %s child status %s, want %s", room, status, want)
+				}
+			}
+			var cancelled []string
+			for _, room := range []string{"1", "2"} {
+				commands := published.commands(rooms[room])
+				if commands[len(commands)-1] == "booking/CANCEL" {
+					cancelled = append(cancelled, room)
+				}
+			}
+			if !reflect.DeepEqual(cancelled, tt.cancelled) {
+				t.Errorf("rooms %v cancelled, want %v", cancelled, tt.cancelled)
+			}
+		})
+	}
+}
+
+func TestSignalStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		signal   func(o *Orchestrator, sagaID string) error
+		err      error
+		step     SagaStep
+		approval SagaStepStatus
+		saga     SagaStatus
+		commands []string
+	}{
+		{
+			name: "approved",
+			signal: func(o *Orchestrator, sagaID string) error {
+				_, err := o.Signal(context.Background(), nil, sagaID, Signal{Name: "approval", Approved: true})
+				return err
+			},
+			step:     "payment",
+			approval: SagaStepStatusSucceeded,
+			saga:     SagaStatusStarted,
+			commands: []string{"booking/REQUEST", "payment/REQUEST"},
+		},
+		{
+			name: "denied",
+			signal: func(o *Orchestrator, sagaID string) error {
+				_, err := o.Signal(context.Background(), nil, sagaID, Signal{Name: "approval"})
+				return err
+			},
+			step:     "booking",
+			approval: SagaStepStatusFailed,
+			saga:     SagaStatusAborting,
+			commands: []string{"booking/REQUEST", "booking/CANCEL"},
+		},
+		{
+			name: "expired",
+			signal: func(o *Orchestrator, sagaID string) error {
+				_, err := o.ExpireDeadlines(context.Background(), nil, time.Now().Add(2*time.Hour))
+				return err
+			},
+			step:     "booking",
+			approval: SagaStepStatusFailed,
+			saga:     SagaStatusAborting,
+			commands: []string{"booking/REQUEST", "booking/CANCEL"},
+		},
+		{
+			name: "not yet expired",
+			signal: func(o *Orchestrator, sagaID string) error {
+				_, err := o.ExpireDeadlines(context.Background(), nil, time.Now())
+				return err
+			},
+			step:     "approval",
+			approval: SagaStepStatusStarted,
+			saga:     SagaStatusStarted,
+			commands: []string{"booking/REQUEST"},
+		},
+		{
+			name: "unexpected signal",
+			signal: func(o *Orchestrator, sagaID string) error {
+				_, err := o.Signal(context.Background(), nil, sagaID, Signal{Name: "payment", Approved: true})
+				return err
+			},
+			err:      ErrUnexpectedSignal,
+			step:     "approval",
+			approval: SagaStepStatusStarted,
+			saga:     SagaStatusStarted,
+			commands: []string{"booking/REQUEST"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &memRepository{sagas: map[string]SagaState{}}
+			published := &commandLog{}
+			o := NewOrchestrator(repository, published, nil, nil, Definition{Type: "approval", Steps: []Step{
+				{Name: "booking", Kind: StepKindParticipant},
+				{Name: "approval", Kind: StepKindSignal, Timeout: time.Hour},
+				{Name: "payment", Kind: StepKindParticipant},
+			}})
+
+			state, err := o.Start(context.Background(), nil, "approval", jsonmap.JSONMap{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := state.ID.String()
+			reply(t, o, id, stepReply{"booking", SagaStepStatusSucceeded})
+			if repository.sagas[id].Deadline == nil {
+				t.Fatal("signal step awaited without deadline")
+			}
+
+			if err := tt.signal(o, id); !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+
+			saved := repository.sagas[id]
+			if saved.CurrentStep != tt.step || saved.SagaStatus != tt.saga {
+				t.Errorf("saga %s at step %s, want %s at step %s", saved.SagaStatus, saved.CurrentStep, tt.saga, tt.step)
+			}
+			if status := stepStatuses(saved)["approval"]; status != string(tt.approval) {
+				t.Errorf("approval step %s, want %s", status, tt.approval)
+			}
+			if commands := published.commands(id); !reflect.DeepEqual(commands, tt.commands) {
+				t.Errorf("commands %v, want %v", commands, tt.commands)
+			}
+		})
+	}
+}
+
+func TestTCCPhases(t *testing.T) {
+	tests := []struct {
+		name     string
+		replies  []stepReply
+		saga     SagaStatus
+		steps    map[string]string
+		commands []string
+	}{
+		{
+			name:     "tries succeeded are confirmed",
+			replies:  []stepReply{{"booking", SagaStepStatusSucceeded}, {"payment", SagaStepStatusSucceeded}},
+			saga:     SagaStatusStarted,
+			steps:    map[string]string{"booking": SagaStepStatusConfirming, "payment": SagaStepStatusConfirming},
+			commands: []string{"booking/TRY", "payment/TRY", "booking/CONFIRM", "payment/CONFIRM"},
+		},
+		{
+			name: "confirmed steps complete the saga",
+			replies: []stepReply{{"booking", SagaStepStatusSucceeded}, {"payment", SagaStepStatusSucceeded},
+				{"payment", SagaStepStatusConfirmed}, {"booking", SagaStepStatusConfirmed}},
+			saga:     SagaStatusCompleted,
+			steps:    map[string]string{"booking": SagaStepStatusConfirmed, "payment": SagaStepStatusConfirmed},
+			commands: []string{"booking/TRY", "payment/TRY", "booking/CONFIRM", "payment/CONFIRM"},
+		},
+		{
+			name:     "failed try cancels the tried steps",
+			replies:  []stepReply{{"booking", SagaStepStatusSucceeded}, {"payment", SagaStepStatusFailed}},
+			saga:     SagaStatusAborting,
+			steps:    map[string]string{"booking": SagaStepStatusCompensating, "payment": SagaStepStatusFailed},
+			commands: []string{"booking/TRY", "payment/TRY", "booking/CANCEL"},
+		},
+		{
+			name: "cancelled steps abort the saga",
+			replies: []stepReply{{"booking", SagaStepStatusSucceeded}, {"payment", SagaStepStatusFailed},
+				{"booking", SagaStepStatusCompensated}},
+			saga:     SagaStatusAborted,
+			steps:    map[string]string{"booking": SagaStepStatusCompensated, "payment": SagaStepStatusFailed},
+			commands: []string{"booking/TRY", "payment/TRY", "booking/CANCEL"},
+		},
+		{
+			name:     "first try failed aborts the saga",
+			replies:  []stepReply{{"booking", SagaStepStatusFailed}},
+			saga:     SagaStatusAborted,
+			steps:    map[string]string{"booking": SagaStepStatusFailed},
+			commands: []string{"booking/TRY"},
+		},
+		{
+			name: "reply of another phase ignored",
+			replies: []stepReply{{"booking", SagaStepStatusSucceeded}, {"payment", SagaStepStatusSucceeded},
+				{"booking", SagaStepStatusCompensated}},
+			saga:     SagaStatusStarted,
+			steps:    map[string]string{"booking": SagaStepStatusConfirming, "payment": SagaStepStatusConfirming},
+			commands: []string{"booking/TRY", "payment/TRY", "booking/CONFIRM", "payment/CONFIRM"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &memRepository{sagas: map[string]SagaState{}}
+			published := &commandLog{}
+			o := NewOrchestrator(repository, published, nil, nil, Definition{Type: "tcc", Protocol: ProtocolTCC, Steps: []Step{
+				{Name: "booking", Kind: StepKindParticipant},
+				{Name: "payment", Kind: StepKindParticipant},
+			}})
+
+			state, err := o.Start(context.Background(), nil, "tcc", jsonmap.JSONMap{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := state.ID.String()
+			for _, r := range tt.replies {
+				reply(t, o, id, r)
+			}
+
+			saved := repository.sagas[id]
+			if saved.SagaStatus != tt.saga {
+				t.Errorf("saga status %s, want %s", saved.SagaStatus, tt.saga)
+			}
+			if steps := stepStatuses(saved); !reflect.DeepEqual(steps, tt.steps) {
+				t.Errorf("step statuses %v, want %v", steps, tt.steps)
+			}
+			if commands := published.commands(id); !reflect.DeepEqual(commands, tt.commands) {
+				t.Errorf("commands %v, want %v", commands, tt.commands)
+			}
+		})
+	}
+}
+
+func TestWaitingSagaResumesOnLockRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  LockPolicy
+		release func(t *testing.T, o *Orchestrator, holder string)
+		step    SagaStepStatus
+		saga    SagaStatus
+		// commands the commands published for the waiting saga
+		commands []string
+	}{
+		{
+			name:   "WAIT granted once the holder completes",
+			policy: LockPolicyWait,
+			release: func(t *testing.T, o *Orchestrator, holder string) {
+				reply(t, o, holder, stepReply{"booking", SagaStepStatusSucceeded})
+			},
+			step:     SagaStepStatusStarted,
+			saga:     SagaStatusStarted,
+			commands: []string{"booking/REQUEST"},
+		},
+		{
+			name:   "QUEUE granted once the holder aborts",
+			policy: LockPolicyQueue,
+			release: func(t *testing.T, o *Orchestrator, holder string) {
+				reply(t, o, holder, stepReply{"booking", SagaStepStatusFailed})
+			},
+			step:     SagaStepStatusStarted,
+			saga:     SagaStatusStarted,
+			commands: []string{"booking/REQUEST"},
+		},
+		{
+			name:   "WAIT timed out",
+			policy: LockPolicyWait,
+			release: func(t *testing.T, o *Orchestrator, holder string) {
+				if _, err := o.ExpireDeadlines(context.Background(), nil, time.Now().Add(2*time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+				reply(t, o, holder, stepReply{"booking", SagaStepStatusSucceeded})
+			},
+			step: SagaStepStatusFailed,
+			saga: SagaStatusAborted,
+		},
+		{
+			name:   "QUEUE without timeout",
+			policy: LockPolicyQueue,
+			release: func(t *testing.T, o *Orchestrator, holder string) {
+				if _, err := o.ExpireDeadlines(context.Background(), nil, time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			step: SagaStepStatusWaiting,
+			saga: SagaStatusStarted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &memRepository{sagas: map[string]SagaState{}}
+			published := &commandLog{}
+			step := roomStep()
+			step.LockPolicy, step.Timeout = tt.policy, time.Minute
+			o := NewOrchestrator(repository, published, newQueuedLocks(), nil, Definition{Type: "room", Steps: []Step{step}})
+
+			start := func() string {
+				state, err := o.Start(context.Background(), nil, "room", jsonmap.JSONMap{"room": "1"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return state.ID.String()
+			}
+			holder, waiting := start(), start()
+
+			saved := repository.sagas[waiting]
+			if status := saved.CurrentStepStatus(); status != SagaStepStatusWaiting {
+				t.Fatalf("waiting saga step %s, want %s", status, SagaStepStatusWaiting)
+			}
+			if (saved.Deadline != nil) != (tt.policy == LockPolicyWait) {
+				t.Errorf("waiting saga deadline %v with policy %s", saved.Deadline, tt.policy)
+			}
+
+			tt.release(t, o, holder)
+
+			saved = repository.sagas[waiting]
+			if status := stepStatuses(saved)["booking"]; status != string(tt.step) {
+				t.Errorf("waiting saga step %s, want %s", status, tt.step)
+			}
+			if saved.SagaStatus != tt.saga {
+				t.Errorf("waiting saga status %s, want %s", saved.SagaStatus, tt.saga)
+			}
+			if tt.step == SagaStepStatusStarted && saved.Deadline != nil {
+				t.Error("resumed saga deadline not cleared")
+			}
+			if commands := published.commands(waiting); !reflect.DeepEqual(commands, tt.commands) {
+				t.Errorf("commands %v, want %v", commands, tt.commands)
+			}
+		})
+	}
+}
@@ -3,26 +3,41 @@ package saga
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"go.example/saga/pkg/jsonmap"
+	"time"
 )
 
+// ErrConcurrentUpdate is returned by Repository.Update when the saga was updated by another TX since it was read
+var ErrConcurrentUpdate = errors.New("saga concurrently updated")
+
 type SagaState struct {
 	ID          uuid.UUID
-	Version     int8
+	ParentID    *uuid.UUID
+	Version     int64
 	Type        string
 	Payload     jsonmap.JSONMap
 	CurrentStep SagaStep
 	StepStatus  jsonmap.JSONMap
 	SagaStatus  SagaStatus
+	Deadline    *time.Time
+	// StepTimes the steps start/end time (RFC3339), e.g. {"payment": {"startedOn": ..., "endedOn": ...}}
+	StepTimes jsonmap.JSONMap
+	StartedOn time.Time
+	EndedOn   *time.Time
 }
 
-// Repository
+// Repository persists the saga states, QueryByID locks the saga row for the current TX (single writer per saga)
+// and Update fails with ErrConcurrentUpdate if the saga version changed since it was read
 type Repository interface {
 	Persist(ctx context.Context, tx *sql.Tx, ss SagaState) error
 	Update(ctx context.Context, tx *sql.Tx, ss SagaState) error
 	QueryByID(ctx context.Context, tx *sql.Tx, ID string) (*SagaState, error)
+	QueryChildren(ctx context.Context, tx *sql.Tx, parentID string) ([]SagaState, error)
+	QueryExpired(ctx context.Context, tx *sql.Tx, now time.Time) ([]SagaState, error)
+	QueryStats(ctx context.Context, tx *sql.Tx) (*Stats, error)
 }
 
 func NewSaga(sagaType string, payload jsonmap.JSONMap, currentStep SagaStep) SagaState {
@@ -34,14 +49,64 @@ func NewSaga(sagaType string, payload jsonmap.JSONMap, currentStep SagaStep) Sag
 		CurrentStep: currentStep,
 		StepStatus:  jsonmap.JSONMap{string(currentStep): SagaStepStatusStarted},
 		SagaStatus:  SagaStatusStarted,
+		StepTimes:   jsonmap.JSONMap{},
+		StartedOn:   time.Now(),
+	}
+}
+
+// CurrentStepStatus returns the status of the current saga step
+func (s *SagaState) CurrentStepStatus() SagaStepStatus {
+	return SagaStepStatus(fmt.Sprintf("%v", s.StepStatus[string(s.CurrentStep)]))
+}
+
+// IsTerminal reports whether the saga reached a final status (COMPLETED or ABORTED)
+func (s *SagaState) IsTerminal() bool {
+	return s.SagaStatus == SagaStatusCompleted || s.SagaStatus == SagaStatusAborted
+}
+
+// stepStarted records the step execution start time
+func (s *SagaState) stepStarted(step SagaStep, t time.Time) {
+	if s.StepTimes == nil {
+		s.StepTimes = jsonmap.JSONMap{}
+	}
+	s.StepTimes[string(step)] = map[string]interface{}{"startedOn": t.UTC().Format(time.RFC3339Nano)}
+}
+
+// stepEnded records the step reply (or signal) time of a started step
+func (s *SagaState) stepEnded(step SagaStep, t time.Time) {
+	times, ok := s.StepTimes[string(step)].(map[string]interface{})
+	if !ok {
+		return
+	}
+	times["endedOn"] = t.UTC().Format(time.RFC3339Nano)
+}
+
+// ended records the saga end time once completed/aborted
+func (s *SagaState) ended(t time.Time) {
+	if s.IsTerminal() && s.EndedOn == nil {
+		s.EndedOn = &t
 	}
 }
 
 // NextSagaStatus evaluate current SagaStepStatuses and set SagaStatus
 func (s *SagaState) NextSagaStatus() {
+	// a pending saga has no step started yet, it waits for a concurrency slot
+	if s.SagaStatus == SagaStatusPending {
+		return
+	}
+
 	ss := map[string]bool{}
 	for _, v := range s.StepStatus {
-		ss[fmt.Sprintf("%v", v)] = true
+		status := fmt.Sprintf("%v", v)
+		// TCC confirmation phase and semantic lock wait: a confirming/waiting step is still in progress,
+		// a confirmed one succeeded
+		switch status {
+		case SagaStepStatusConfirming, SagaStepStatusWaiting:
+			status = SagaStepStatusStarted
+		case SagaStepStatusConfirmed:
+			status = SagaStepStatusSucceeded
+		}
+		ss[status] = true
 	}
 
 	if ss[SagaStepStatusSucceeded] && len(ss) == 1 {
@@ -65,6 +130,7 @@ type SagaStatus string
 
 // SagaStatus type
 const (
+	SagaStatusPending   = "PENDING"
 	SagaStatusStarted   = "STARTED"
 	SagaStatusAborting  = "ABORTING"
 	SagaStatusAborted   = "ABORTED"
@@ -81,6 +147,9 @@ const (
 	SagaStepStatusSucceeded    = "SUCCEEDED"
 	SagaStepStatusCompensating = "COMPENSATING"
 	SagaStepStatusCompensated  = "COMPENSATED"
+	SagaStepStatusConfirming   = "CONFIRMING"
+	SagaStepStatusConfirmed    = "CONFIRMED"
+	SagaStepStatusWaiting      = "WAITING"
 )
 
 // SagaStep define saga service step in order to follow
@@ -0,0 +1,23 @@
+package saga
+
+// Stats aggregates the sagas outcome and durations, computed from the store
+type Stats struct {
+	// Sagas count by saga status
+	Sagas map[string]int `json:"sagas"`
+	// CompensationRate the share of the completed/aborted sagas which compensated at least one step
+	CompensationRate float64 `json:"compensationRate"`
+	// Durations the completed/aborted sagas duration by saga type
+	Durations []DurationStats `json:"durations"`
+	// Steps the steps duration (request to reply) by saga type and step
+	Steps []DurationStats `json:"steps"`
+}
+
+// DurationStats the duration percentiles in milliseconds
+type DurationStats struct {
+	Type  string   `json:"type"`
+	Step  SagaStep `json:"step,omitempty"`
+	Count int      `json:"count"`
+	P50   float64  `json:"p50Ms"`
+	P95   float64  `json:"p95Ms"`
+	P99   float64  `json:"p99Ms"`
+}
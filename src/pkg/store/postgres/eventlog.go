@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
 )
@@ -10,8 +11,9 @@ import (
 // EventLog keeps the consumed events from kafka ingester
 // kafka follows the at least once semantic, message log ensure consumed events are tracked
 type EventLog struct {
-	EventID  string
-	IssuedOn time.Time
+	ConsumerGroup string
+	EventID       string
+	IssuedOn      time.Time
 }
 
 // EventLogs defines the data access type for kafka consumed events
@@ -23,20 +25,22 @@ func NewEventLogs() *EventLogs {
 	return &EventLogs{}
 }
 
-// IsConsumed check if provided eventId is already exit into the DB in the current TX
-func (el EventLogs) IsConsumed(ctx context.Context, tx *sql.Tx, eventID string) bool {
-	var consumed bool
-	row := tx.QueryRowContext(ctx, "SELECT count(event_id)=1 FROM eventlog WHERE event_id=$1", eventID)
-	if err := row.Scan(&consumed); err == nil && consumed {
-		log.Printf("Event %s already consumed", eventID)
-		return true
+// TryConsume records the event consumed by the consumer group in the current TX, returns false when the event was
+// already consumed by the group. A concurrent TX consuming the same event waits until the other TX ends.
+func (el EventLogs) TryConsume(ctx context.Context, tx *sql.Tx, consumerGroup string, eventID string) (bool, error) {
+	q := `INSERT INTO eventlog(consumer_group, event_id, issued_on) VALUES ($1,$2,$3)
+		ON CONFLICT (consumer_group, event_id) DO NOTHING`
+	res, err := tx.ExecContext(ctx, q, consumerGroup, eventID, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("consume event %s: %w", eventID, err)
 	}
-	return false
-}
 
-// Consume insert the provided eventId into consumed message array in the current TX
-func (el EventLogs) Consume(ctx context.Context, tx *sql.Tx, eventID string) error {
-	// consume the event
-	_, err := tx.ExecContext(ctx, "INSERT INTO eventlog(event_id, issued_on) VALUES ($1,$2)", eventID, time.Now())
-	return err
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		log.Printf("Event %s already consumed by %s", eventID, consumerGroup)
+	}
+	return n == 1, nil
 }
@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"go.example/saga/pkg/messaging"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type InboxStatus string
+
+// InboxStatus type
+const (
+	InboxStatusPending = "PENDING"
+	InboxStatusFailed  = "FAILED"
+)
+
+// receiveRetryInterval the delay before storing again a received message, e.g. while the database is unavailable
+const receiveRetryInterval = time.Second
+
+// InboxMessage a raw message received by a consumer group, kept until processed
+type InboxMessage struct {
+	messaging.Message
+	ID         int64
+	Status     InboxStatus
+	Attempts   int
+	LastError  *string
+	ReceivedOn time.Time
+}
+
+// InboxProps contain the inbox processing settings
+type InboxProps struct {
+	// Workers the number of messages of a consumer group processed concurrently, 1 by default
+	Workers int
+	// Interval between two polls of the inbox table when no message is pending
+	Interval time.Duration
+	// MaxAttempts the number of times a message is processed before it is failed, 5 by default
+	MaxAttempts int
+	// Backoff the delay before the first retry, doubled on each attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Inbox stores the received messages durably before they are processed asynchronously by a worker pool,
+// the messages failing are retried with backoff and then kept FAILED for inspection and replay.
+// The messages sharing a key are processed in order, a FAILED message no longer holds the next ones.
+type Inbox struct {
+	store *Store
+	props InboxProps
+}
+
+// NewInbox constructor
+func NewInbox(store *Store, props InboxProps) *Inbox {
+	if props.Workers <= 0 {
+		props.Workers = 1
+	}
+	if props.MaxAttempts <= 0 {
+		props.MaxAttempts = 5
+	}
+	return &Inbox{store, props}
+}
+
+// Add stores the received message unless the consumer group already received the event,
+// returns false when the message is a duplicate
+func (in *Inbox) Add(ctx context.Context, m messaging.Message) (bool, error) {
+	return Transact(ctx, in.store, TxOptions{}, func(tx *sql.Tx) (bool, error) {
+		q := `INSERT INTO inbox(consumer_group, event_id, event_type, topic, kafka_partition, kafka_offset, msg_key,
+			timestamp, headers, payload) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+			ON CONFLICT (consumer_group, event_id) DO NOTHING`
+		res, err := tx.ExecContext(ctx, q, m.Group, m.EventID(), m.EventType(), m.Topic, m.Partition, m.Offset,
+			m.Key, m.Timestamp, Headers(m.Headers), m.Value)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n == 1, err
+	})
+}
+
+// Receive adds the received message, retried until stored or the context is done
+func (in *Inbox) Receive(ctx context.Context, m messaging.Message) error {
+	for {
+		_, err := in.Add(ctx, m)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to store message %s/%d/%d in inbox: %v", m.Topic, m.Partition, m.Offset, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(receiveRetryInterval):
+		}
+	}
+}
+
+// Start processes the pending messages of the consumer group with the worker pool until the context is done,
+// the messages in flight are processed before returning
+func (in *Inbox) Start(ctx context.Context, consumerGroup string, handler messaging.Handler) error {
+	if in.props.Interval <= 0 {
+		return errors.New("inbox interval must be positive")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < in.props.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in.work(ctx, consumerGroup, handler)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// work processes the messages one after the other, and polls the inbox when none is pending
+func (in *Inbox) work(ctx context.Context, consumerGroup string, handler messaging.Handler) {
+	for ctx.Err() == nil {
+		// the message in flight is processed to completion when the context is done
+		processed, err := in.process(context.WithoutCancel(ctx), consumerGroup, handler)
+		if err != nil {
+			log.Printf("Failed to process %s inbox: %v", consumerGroup, err)
+		}
+		if err == nil && processed {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(in.props.Interval):
+		}
+	}
+}
+
+// process claims the next pending message and handles it, the message is deleted once processed
+// or its failed attempt recorded, returns false when no message is pending
+func (in *Inbox) process(ctx context.Context, consumerGroup string, handler messaging.Handler) (bool, error) {
+	return Transact(ctx, in.store, TxOptions{}, func(tx *sql.Tx) (bool, error) {
+		m, err := in.claim(ctx, tx, consumerGroup)
+		if err != nil || m == nil {
+			return false, err
+		}
+
+		if err := handler(ctx, m.Message); err != nil {
+			return true, in.retry(ctx, tx, *m, err)
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM inbox WHERE id=$1", m.ID)
+		return true, err
+	})
+}
+
+// claim locks the next pending message of the consumer group whose key has no earlier pending message
+func (in *Inbox) claim(ctx context.Context, tx *sql.Tx, consumerGroup string) (*InboxMessage, error) {
+	q := `SELECT ` + inboxColumns + ` FROM inbox i
+		WHERE consumer_group=$1 AND status=$2 AND next_attempt_on <= CURRENT_TIMESTAMP
+		AND NOT EXISTS (SELECT 1 FROM inbox p WHERE p.consumer_group=i.consumer_group AND p.msg_key=i.msg_key
+			AND p.status=$2 AND p.id < i.id)
+		ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED`
+	m, err := scanInbox(tx.QueryRowContext(ctx, q, consumerGroup, InboxStatusPending))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// retry records the failed attempt, the message is retried after the backoff or failed after MaxAttempts,
+// a permanent failure fails the message at once
+func (in *Inbox) retry(ctx context.Context, tx *sql.Tx, m InboxMessage, cause error) error {
+	attempts := m.Attempts + 1
+	status := InboxStatus(InboxStatusPending)
+	if attempts >= in.props.MaxAttempts || errors.Is(cause, messaging.ErrPermanent) {
+		status = InboxStatusFailed
+	}
+
+	backoff := in.props.Backoff
+	for i := 1; i < attempts && (in.props.MaxBackoff <= 0 || backoff < in.props.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if in.props.MaxBackoff > 0 && backoff > in.props.MaxBackoff {
+		backoff = in.props.MaxBackoff
+	}
+
+	log.Printf("Inbox message %d eventID %s attempt %d failed (%s): %v", m.ID, m.EventID(), attempts, status, cause)
+	q := "UPDATE inbox SET status=$2, attempts=$3, last_error=$4, next_attempt_on=$5 WHERE id=$1"
+	_, err := tx.ExecContext(ctx, q, m.ID, status, attempts, cause.Error(), time.Now().Add(backoff))
+	return err
+}
+
+// Failed returns the failed messages, oldest first
+func (in *Inbox) Failed(ctx context.Context) ([]InboxMessage, error) {
+	return Transact(ctx, in.store, ReadOnlyTx, func(tx *sql.Tx) ([]InboxMessage, error) {
+		rows, err := tx.QueryContext(ctx, "SELECT "+inboxColumns+" FROM inbox WHERE status=$1 ORDER BY id", InboxStatusFailed)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var messages []InboxMessage
+		for rows.Next() {
+			m, err := scanInbox(rows)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, *m)
+		}
+		return messages, rows.Err()
+	})
+}
+
+// Replay sets the failed messages pending again, all the failed messages when no ID is provided,
+// returns the number of messages replayed
+func (in *Inbox) Replay(ctx context.Context, ids ...int64) (int64, error) {
+	return Transact(ctx, in.store, TxOptions{}, func(tx *sql.Tx) (int64, error) {
+		q := `UPDATE inbox SET status=$1, attempts=0, last_error=NULL, next_attempt_on=CURRENT_TIMESTAMP
+			WHERE status=$2 AND (cardinality($3::bigint[]) = 0 OR id = ANY($3::bigint[]))`
+		res, err := tx.ExecContext(ctx, q, InboxStatusPending, InboxStatusFailed, pq.Array(ids))
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	})
+}
+
+// Run runs the inbox command: list (the failed messages) or replay all|<id>..., the output is written to w
+func (in *Inbox) Run(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: inbox list|replay all|replay <id>...")
+	}
+
+	switch args[0] {
+	case "list":
+		messages, err := in.Failed(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range messages {
+			lastError := ""
+			if m.LastError != nil {
+				lastError = *m.LastError
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n",
+				m.ID, m.Group, m.EventID(), m.EventType(), m.Key, m.Attempts, lastError)
+		}
+		return nil
+	case "replay":
+		if len(args) < 2 {
+			return errors.New("usage: inbox replay all|<id>...")
+		}
+		var ids []int64
+		if args[1] != "all" {
+			for _, a := range args[1:] {
+				id, err := strconv.ParseInt(a, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid inbox message id %q", a)
+				}
+				ids = append(ids, id)
+			}
+		}
+		n, err := in.Replay(ctx, ids...)
+		_, _ = fmt.Fprintf(w, "%d messages replayed\n", n)
+		return err
+	default:
+		return fmt.Errorf("unknown inbox command %q, expected list or replay", args[0])
+	}
+}
+
+const inboxColumns = `id, consumer_group, topic, kafka_partition, kafka_offset, msg_key, timestamp, headers, payload,
+	status, attempts, last_error, received_on`
+
+func scanInbox(row scanner) (*InboxMessage, error) {
+	var m InboxMessage
+	if err := row.Scan(&m.ID, &m.Group, &m.Topic, &m.Partition, &m.Offset, &m.Key, &m.Timestamp, (*Headers)(&m.Headers),
+		&m.Value, &m.Status, &m.Attempts, &m.LastError, &m.ReceivedOn); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+// semantic lock statuses
+const (
+	lockStatusHeld    = "HELD"
+	lockStatusWaiting = "WAITING"
+)
+
+// SagaLocks defines the data access type for the saga semantic locks (countermeasure for the saga isolation),
+// a lock is held by one saga at a time and the other sagas wait in FIFO order
+type SagaLocks struct {
+}
+
+// NewSagaLocks constructor
+func NewSagaLocks() *SagaLocks {
+	return &SagaLocks{}
+}
+
+// Acquire takes the named lock for the saga, or queues the saga as waiter when wait is true, returns whether acquired
+func (sl SagaLocks) Acquire(ctx context.Context, tx *sql.Tx, name string, sagaID string, wait bool) (bool, error) {
+	// the unique index on the held lock name lets a single saga insert it, concurrent TXs wait for the first one
+	q := "INSERT INTO sagalock(name, saga_id, status) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING"
+	res, err := tx.ExecContext(ctx, q, name, sagaID, lockStatusHeld)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return n == 1, err
+	}
+
+	// reentrant lock, the saga may already hold or wait for it
+	var status string
+	row := tx.QueryRowContext(ctx, "SELECT status FROM sagalock WHERE name=$1 AND saga_id=$2", name, sagaID)
+	if err := row.Scan(&status); err == nil {
+		return status == lockStatusHeld, nil
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if !wait {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO sagalock(name, saga_id, status) VALUES ($1,$2,$3)", name, sagaID, lockStatusWaiting)
+	return false, err
+}
+
+// Dequeue removes the saga from the named lock waiters
+func (sl SagaLocks) Dequeue(ctx context.Context, tx *sql.Tx, name string, sagaID string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM sagalock WHERE name=$1 AND saga_id=$2 AND status=$3", name, sagaID, lockStatusWaiting)
+	return err
+}
+
+// Release releases all the saga locks, grants them to the oldest waiters and returns the sagas granted
+func (sl SagaLocks) Release(ctx context.Context, tx *sql.Tx, sagaID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "DELETE FROM sagalock WHERE saga_id=$1 RETURNING name, status", sagaID)
+	if err != nil {
+		return nil, err
+	}
+
+	var released []string
+	for rows.Next() {
+		var name, status string
+		if err := rows.Scan(&name, &status); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		if status == lockStatusHeld {
+			released = append(released, name)
+		}
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var granted []string
+	for _, name := range released {
+		q := `UPDATE sagalock SET status=$1
+			WHERE name=$2 AND saga_id=(SELECT saga_id FROM sagalock WHERE name=$2 AND status=$3 ORDER BY requested_on LIMIT 1)
+			RETURNING saga_id`
+		var next string
+		err := tx.QueryRowContext(ctx, q, lockStatusHeld, name, lockStatusWaiting).Scan(&next)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		granted = append(granted, next)
+	}
+	return granted, nil
+}
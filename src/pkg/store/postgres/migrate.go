@@ -0,0 +1,254 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationFile matches the migration files, e.g. 0001_init.up.sql and 0001_init.down.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration defines a numbered schema migration and its rollback
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus the migration and the time it was applied, nil when pending
+type MigrationStatus struct {
+	Migration
+	AppliedOn *time.Time
+}
+
+// Migrator applies the service schema migrations in version order, the applied versions are tracked
+// in the schema_migrations table and the concurrent migrators (e.g. replicas starting together) are serialized
+type Migrator struct {
+	store      *Store
+	migrations []Migration
+}
+
+// NewMigrator constructor, the migrations are read from the root of fsys (e.g. an embed.FS sub directory)
+func NewMigrator(store *Store, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d named both %s and %s", version, m.Name, match[2])
+		}
+
+		content, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return &Migrator{store, migrations}, nil
+}
+
+// Up applies the pending migrations, one TX per migration, returns the number of migrations applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	for _, migration := range m.migrations {
+		ok, err := Transact(ctx, m.store, TxOptions{}, func(tx *sql.Tx) (bool, error) {
+			versions, err := lockMigrations(ctx, tx)
+			if err != nil {
+				return false, err
+			}
+			// applied meanwhile by another migrator
+			if _, ok := versions[migration.Version]; ok {
+				return false, nil
+			}
+
+			if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+				return false, fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			q := "INSERT INTO schema_migrations(version, name) VALUES ($1,$2)"
+			_, err = tx.ExecContext(ctx, q, migration.Version, migration.Name)
+			return err == nil, err
+		})
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// Down reverts the last applied migrations, at most steps migrations, returns the number of migrations reverted
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	for ; reverted < steps; reverted++ {
+		migration, err := Transact(ctx, m.store, TxOptions{}, func(tx *sql.Tx) (*Migration, error) {
+			versions, err := lockMigrations(ctx, tx)
+			if err != nil || len(versions) == 0 {
+				return nil, err
+			}
+
+			last := -1
+			for v := range versions {
+				if v > last {
+					last = v
+				}
+			}
+			migration := m.migration(last)
+			if migration == nil || migration.Down == "" {
+				return nil, fmt.Errorf("migration %d cannot be reverted, no down script", last)
+			}
+
+			if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+				return nil, fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version=$1", migration.Version)
+			return migration, err
+		})
+		if err != nil || migration == nil {
+			return reverted, err
+		}
+		log.Printf("Reverted migration %d_%s", migration.Version, migration.Name)
+	}
+	return reverted, nil
+}
+
+// Status returns the migrations with the time they were applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	return Transact(ctx, m.store, TxOptions{}, func(tx *sql.Tx) ([]MigrationStatus, error) {
+		versions, err := lockMigrations(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		status := make([]MigrationStatus, 0, len(m.migrations))
+		for _, migration := range m.migrations {
+			s := MigrationStatus{Migration: migration}
+			if appliedOn, ok := versions[migration.Version]; ok {
+				s.AppliedOn = &appliedOn
+			}
+			status = append(status, s)
+		}
+		return status, nil
+	})
+}
+
+// Run runs the migrate command: up, down [steps] (1 by default) or status, the output is written to w
+func (m *Migrator) Run(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [steps]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		_, _ = fmt.Fprintf(w, "%d migrations applied\n", n)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid down steps %q", args[1])
+			}
+		}
+		n, err := m.Down(ctx, steps)
+		_, _ = fmt.Fprintf(w, "%d migrations reverted\n", n)
+		return err
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedOn != nil {
+				applied = "applied " + s.AppliedOn.Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(w, "%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+}
+
+func (m *Migrator) migration(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// lockMigrations locks the schema migrations until the TX ends and returns the applied versions
+func lockMigrations(ctx context.Context, tx *sql.Tx) (map[int]time.Time, error) {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))"); err != nil {
+		return nil, err
+	}
+
+	q := `CREATE TABLE IF NOT EXISTS schema_migrations
+	(
+		version    INT PRIMARY KEY,
+		name       VARCHAR(255) NOT NULL,
+		applied_on TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := tx.ExecContext(ctx, q); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT version, applied_on FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := map[int]time.Time{}
+	for rows.Next() {
+		var v int
+		var appliedOn time.Time
+		if err := rows.Scan(&v, &appliedOn); err != nil {
+			return nil, err
+		}
+		versions[v] = appliedOn
+	}
+	return versions, rows.Err()
+}
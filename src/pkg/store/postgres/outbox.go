@@ -3,23 +3,36 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"go.example/saga/pkg/jsonmap"
+	"go.example/saga/pkg/messaging"
+	"go.example/saga/pkg/saga"
 	"time"
 )
 
 // OutboxEvent represent outbox representation expected by debezium strimzi connect
 type OutboxEvent struct {
-	ID            uuid.UUID
-	Timestamp     time.Time
-	AggregateID   string
-	AggregateType string
-	Type          string
-	Payload       jsonmap.JSONMap
+	ID            uuid.UUID       `json:"id"`
+	Timestamp     time.Time       `json:"timestamp"`
+	AggregateID   string          `json:"aggregateId"`
+	AggregateType string          `json:"aggregateType"`
+	Type          string          `json:"type"`
+	Payload       jsonmap.JSONMap `json:"payload"`
+	Headers       Headers         `json:"headers"`
 }
 
-// NewEvent factory method for building an event
-func NewEvent(aggregateID, aggregateType, eventType string, payload jsonmap.JSONMap) OutboxEvent {
+// NewEvent factory method for building an event, the provided headers are merged in order
+func NewEvent(aggregateID, aggregateType, eventType string, payload jsonmap.JSONMap, headers ...Headers) OutboxEvent {
+	h := Headers{}
+	for _, hs := range headers {
+		for k, v := range hs {
+			h[k] = v
+		}
+	}
+
 	return OutboxEvent{
 		ID:            uuid.New(),
 		Timestamp:     time.Now(),
@@ -27,7 +40,49 @@ func NewEvent(aggregateID, aggregateType, eventType string, payload jsonmap.JSON
 		AggregateType: aggregateType,
 		Type:          eventType,
 		Payload:       payload,
+		Headers:       h,
+	}
+}
+
+// Headers keys
+const (
+	HeaderTraceID       = messaging.MetadataTraceID
+	HeaderCorrelationID = messaging.MetadataCorrelationID
+	HeaderCausationID   = messaging.MetadataCausationID
+	HeaderSchemaVersion = messaging.MetadataSchemaVersion
+	HeaderTenant        = messaging.MetadataTenant
+)
+
+// Headers the outbox event metadata (trace, correlation, causation IDs, schema version, tenant)
+type Headers map[string]string
+
+// Caused returns the headers of an event caused by the event with the provided ID and headers:
+// the trace, correlation ID and tenant are propagated, the causation ID is the causing event ID
+func (h Headers) Caused(eventID string) Headers {
+	caused := Headers{HeaderCausationID: eventID}
+	for _, k := range []string{HeaderTraceID, HeaderCorrelationID, HeaderTenant} {
+		if v, ok := h[k]; ok {
+			caused[k] = v
+		}
 	}
+	return caused
+}
+
+// Value impl of Valuer - to JSON marshal
+func (h Headers) Value() (driver.Value, error) {
+	if h == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(h)
+}
+
+// Scan impl of Scanner - from JSON unmarshal
+func (h *Headers) Scan(src interface{}) error {
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("type assertion .([]byte) failed")
+	}
+	return json.Unmarshal(source, h)
 }
 
 // EventType defines the payload event type
@@ -36,15 +91,72 @@ type EventType string
 const (
 	RequestEventType = "REQUEST"
 	CancelEventType  = "CANCEL"
+	// TCC protocol event types
+	TryEventType     = "TRY"
+	ConfirmEventType = "CONFIRM"
 )
 
+// OutboxChannel the channel notified on commit of the TXs persisting outbox events
+const OutboxChannel = "outboxevent"
+
 // FIXME refactor to a proper implementaiton
-// Persist the outbox event within the provided Transaction and Context
+// Persist the outbox event within the provided Transaction and Context,
+// the listening relays are notified once the TX commits (postgres delivers the notifications on commit)
 func (oe *OutboxEvent) Persist(ctx context.Context, tx *sql.Tx) error {
-	q := "INSERT INTO outboxevent(id, timestamp, aggregatetype, aggregateid, type, payload) VALUES ($1,$2,$3,$4,$5,$6)"
-	if _, err := tx.ExecContext(ctx, q, oe.ID, oe.Timestamp, oe.AggregateType, oe.AggregateID, oe.Type, oe.Payload); err != nil {
+	q := "INSERT INTO outboxevent(id, timestamp, aggregatetype, aggregateid, type, payload, headers) VALUES ($1,$2,$3,$4,$5,$6,$7)"
+	if _, err := tx.ExecContext(ctx, q, oe.ID, oe.Timestamp, oe.AggregateType, oe.AggregateID, oe.Type, oe.Payload, oe.Headers); err != nil {
+		return err
+	}
+
+	// the notifications of a TX with the same channel and payload are folded into one
+	if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", OutboxChannel, oe.AggregateType); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// QueryEvents fetch the outbox events of the aggregate persisted so far, including the ones of the current TX
+func QueryEvents(ctx context.Context, tx *sql.Tx, aggregateID string) ([]OutboxEvent, error) {
+	q := `SELECT id, timestamp, aggregatetype, aggregateid, type, payload, headers FROM outboxevent
+		WHERE aggregateid = $1 ORDER BY timestamp, id`
+	rows, err := tx.QueryContext(ctx, q, aggregateID)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// scanEvents scans the outbox event rows and closes them
+func scanEvents(rows *sql.Rows) ([]OutboxEvent, error) {
+	defer rows.Close()
+
+	var events []OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.AggregateType, &e.AggregateID, &e.Type, &e.Payload, &e.Headers); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// OutboxPublisher publishes saga commands to the step participants through the outbox table
+type OutboxPublisher struct {
+}
+
+// NewOutboxPublisher constructor
+func NewOutboxPublisher() *OutboxPublisher {
+	return &OutboxPublisher{}
+}
+
+// sagaSchemaVersion the schema version of the saga command payloads
+const sagaSchemaVersion = "1"
+
+// Publish persist the saga command as an outbox event routed by the step name, correlated by the saga ID
+func (op OutboxPublisher) Publish(ctx context.Context, tx *sql.Tx, sagaID string, step saga.SagaStep, command saga.Command, payload jsonmap.JSONMap) error {
+	headers := Headers{HeaderCorrelationID: sagaID, HeaderSchemaVersion: sagaSchemaVersion}
+	outboxEvent := NewEvent(sagaID, string(step), string(command), payload, headers)
+	return outboxEvent.Persist(ctx, tx)
+}
@@ -0,0 +1,74 @@
+// Package postgrestest provides the stores of the database tests, isolated from the data of the database used
+package postgrestest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"go.example/saga/pkg/store/postgres"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+// NewStore connects to the database of the props on the POSTGRES_HOST server within a dedicated schema, created and
+// migrated for the test and dropped once the test completes: the tables of the database are left untouched.
+// The test is skipped when POSTGRES_HOST is not set.
+func NewStore(t testing.TB, props postgres.StoreProps, migrations fs.FS) *postgres.Store {
+	t.Helper()
+	props.Host = os.Getenv("POSTGRES_HOST")
+	if props.Host == "" {
+		t.Skip("POSTGRES_HOST not set, skipping the database test")
+	}
+
+	admin, err := postgres.NewStore(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	props.Schema = "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	Exec(t, admin, fmt.Sprintf("CREATE SCHEMA %s", props.Schema))
+	t.Cleanup(func() {
+		Exec(t, admin, fmt.Sprintf("DROP SCHEMA %s CASCADE", props.Schema))
+		_ = admin.Close()
+	})
+
+	st, err := postgres.NewStore(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+
+	migrator, err := postgres.NewMigrator(st, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// Exec runs the statement in its own TX
+func Exec(t testing.TB, st *postgres.Store, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := st.Transact(context.Background(), func(tx *sql.Tx) (interface{}, error) {
+		return tx.Exec(query, args...)
+	}); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// Count returns the single int result of the query
+func Count(t testing.TB, st *postgres.Store, query string, args ...interface{}) int {
+	t.Helper()
+	n, err := postgres.Transact(context.Background(), st, postgres.ReadOnlyTx, func(tx *sql.Tx) (int, error) {
+		var n int
+		return n, tx.QueryRow(query, args...).Scan(&n)
+	})
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
@@ -0,0 +1,187 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"go.example/saga/pkg/messaging"
+	"log"
+	"strings"
+	"time"
+)
+
+// aggregateTypePlaceholder is replaced by the outbox event aggregate type in the relay topic
+const aggregateTypePlaceholder = "${aggregatetype}"
+
+// RelayProps contain the outbox relay settings
+type RelayProps struct {
+	// Topic the topic routing, ${aggregatetype} is replaced by the event aggregate type as the debezium EventRouter does,
+	// ${aggregatetype}.outbox.events when empty
+	Topic string
+	// Interval between two polls of the outbox table
+	Interval time.Duration
+	// BatchSize the max number of outbox events published per TX
+	BatchSize int
+	// DeletePublished deletes the published outbox rows, or marks them published (published_on) when false
+	DeletePublished bool
+	// Listen publishes the outbox events as soon as their TX commits (LISTEN/NOTIFY), Interval is the fallback poll
+	Listen bool
+	// CloudEvents publishes the events as CloudEvents 1.0 of the content mode (binary or structured),
+	// with the debezium EventRouter conventions when empty
+	CloudEvents string
+	// Source the CloudEvents source of the events, e.g. /reservation-service
+	Source string
+}
+
+// Relay publishes the outbox events without Kafka Connect, the events are published with the debezium
+// EventRouter conventions: aggregateid as key, payload as value, id, eventType and headers (metadata) headers,
+// or as CloudEvents.
+// The relay replicas share the outbox rows (FOR UPDATE SKIP LOCKED), the events are published at least once.
+type Relay struct {
+	store     *Store
+	publisher messaging.Publisher
+	props     RelayProps
+}
+
+// NewRelay constructor
+func NewRelay(store *Store, publisher messaging.Publisher, props RelayProps) *Relay {
+	if props.Topic == "" {
+		props.Topic = aggregateTypePlaceholder + ".outbox.events"
+	}
+	if props.BatchSize <= 0 {
+		props.BatchSize = 100
+	}
+	return &Relay{store, publisher, props}
+}
+
+// Start polls and publishes the outbox events periodically until the context is done.
+// When listening the events are published on notification, and after each reconnection (notifications missed)
+func (r *Relay) Start(ctx context.Context) error {
+	if r.props.Interval <= 0 {
+		return errors.New("outbox relay interval must be positive")
+	}
+	if r.props.CloudEvents != "" && r.props.CloudEvents != messaging.CloudEventsBinary && r.props.CloudEvents != messaging.CloudEventsStructured {
+		return fmt.Errorf("unknown CloudEvents mode %q, expected binary or structured", r.props.CloudEvents)
+	}
+
+	ticker := time.NewTicker(r.props.Interval)
+	defer ticker.Stop()
+
+	var notifications <-chan *pq.Notification
+	if r.props.Listen {
+		listener := pq.NewListener(r.store.url, 100*time.Millisecond, 10*time.Second, func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("Outbox listener event %d: %v", ev, err)
+			}
+		})
+		defer func() {
+			_ = listener.Close()
+		}()
+
+		if err := listener.Listen(OutboxChannel); err != nil {
+			return err
+		}
+		notifications = listener.NotificationChannel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case n := <-notifications:
+			// a nil notification is sent once reconnected, the pending events are caught up
+			if n == nil {
+				log.Printf("Outbox listener reconnected, catching up")
+			}
+		}
+
+		if _, err := r.Run(ctx); err != nil {
+			log.Printf("Failed to relay outbox events: %v", err)
+		}
+	}
+}
+
+// Run publishes the pending outbox events batch after batch, returns the number of events published
+func (r *Relay) Run(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := Transact(ctx, r.store, TxOptions{}, func(tx *sql.Tx) (int, error) {
+			return r.relay(ctx, tx)
+		})
+		total += n
+		if err != nil || n < r.props.BatchSize {
+			return total, err
+		}
+	}
+}
+
+// relay publishes a batch of pending outbox events and marks them published within the TX,
+// the TX is rolled back (the events published again) when any delivery fails
+func (r *Relay) relay(ctx context.Context, tx *sql.Tx) (int, error) {
+	q := `SELECT id, timestamp, aggregatetype, aggregateid, type, payload, headers FROM outboxevent
+		WHERE published_on IS NULL ORDER BY timestamp LIMIT $1 FOR UPDATE SKIP LOCKED`
+	rows, err := tx.QueryContext(ctx, q, r.props.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	events, err := scanEvents(rows)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	if err := r.publish(ctx, events); err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID.String())
+	}
+	q = "UPDATE outboxevent SET published_on=CURRENT_TIMESTAMP WHERE id = ANY($1::uuid[])"
+	if r.props.DeletePublished {
+		q = "DELETE FROM outboxevent WHERE id = ANY($1::uuid[])"
+	}
+	if _, err := tx.ExecContext(ctx, q, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// publish publishes the events in order and waits for their acknowledgement
+func (r *Relay) publish(ctx context.Context, events []OutboxEvent) error {
+	msgs := make([]messaging.Message, 0, len(events))
+	for _, e := range events {
+		value, err := e.Payload.Value()
+		if err != nil {
+			return err
+		}
+		headers, err := e.Headers.Value()
+		if err != nil {
+			return err
+		}
+
+		msg := messaging.Message{
+			Topic:     strings.ReplaceAll(r.props.Topic, aggregateTypePlaceholder, e.AggregateType),
+			Key:       e.AggregateID,
+			Value:     value.([]byte),
+			Timestamp: e.Timestamp,
+			Headers: map[string]string{
+				messaging.HeaderID:        e.ID.String(),
+				messaging.HeaderEventType: e.Type,
+				messaging.HeaderMetadata:  string(headers.([]byte)),
+			},
+		}
+		// the saga ID (aggregateid) is the CloudEvent subject
+		if r.props.CloudEvents != "" {
+			if msg, err = messaging.ToCloudEvent(msg, r.props.CloudEvents, r.props.Source); err != nil {
+				return err
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	return r.publisher.Publish(ctx, msgs...)
+}
@@ -0,0 +1,110 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"go.example/saga/pkg/jsonmap"
+	"go.example/saga/pkg/messaging"
+	"go.example/saga/pkg/store/postgres"
+	"testing"
+	"time"
+)
+
+// chanPublisher hands over the published messages
+type chanPublisher chan messaging.Message
+
+func (p chanPublisher) Publish(ctx context.Context, msgs ...messaging.Message) error {
+	for _, m := range msgs {
+		select {
+		case p <- m:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// startRelay starts the relay until the test ends, returns once the relay publishes the events persisted
+func startRelay(t testing.TB, st *postgres.Store, props postgres.RelayProps) chanPublisher {
+	t.Helper()
+	published := make(chanPublisher, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- postgres.NewRelay(st, published, props).Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	// the events persisted before the relay listens are published on the next poll
+	deadline := time.After(props.Interval + 10*time.Second)
+	for {
+		persistEvent(t, st)
+		select {
+		case <-published:
+			time.Sleep(100 * time.Millisecond)
+			for len(published) > 0 {
+				<-published
+			}
+			return published
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("relay not publishing")
+		}
+	}
+}
+
+func persistEvent(t testing.TB, st *postgres.Store) {
+	t.Helper()
+	e := postgres.NewEvent(uuid.NewString(), "relay", "TEST", jsonmap.JSONMap{"n": 1})
+	if _, err := st.Transact(context.Background(), func(tx *sql.Tx) (interface{}, error) {
+		return nil, e.Persist(context.Background(), tx)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// latency persists an event and returns the time until it is published
+func latency(t testing.TB, st *postgres.Store, published chanPublisher) time.Duration {
+	start := time.Now()
+	persistEvent(t, st)
+	<-published
+	return time.Since(start)
+}
+
+func TestRelayPublishesOnCommitWhenListening(t *testing.T) {
+	st := testStore(t)
+	published := startRelay(t, st, postgres.RelayProps{Interval: time.Minute, Listen: true, DeletePublished: true})
+
+	if d := latency(t, st, published); d > 5*time.Second {
+		t.Errorf("event published after %s, want on commit", d)
+	}
+}
+
+// BenchmarkRelayLatency measures the insert to publish latency, on notification (LISTEN/NOTIFY) and polling only
+func BenchmarkRelayLatency(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		props postgres.RelayProps
+	}{
+		{"notify", postgres.RelayProps{Interval: time.Minute, Listen: true, DeletePublished: true}},
+		{"polling", postgres.RelayProps{Interval: 500 * time.Millisecond, DeletePublished: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			st := testStore(b)
+			published := startRelay(b, st, bc.props)
+
+			b.ResetTimer()
+			var total time.Duration
+			for i := 0; i < b.N; i++ {
+				total += latency(b, st, published)
+			}
+			b.ReportMetric(float64(total.Microseconds())/float64(b.N), "µs/event")
+		})
+	}
+}
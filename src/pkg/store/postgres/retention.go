@@ -0,0 +1,219 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.example/saga/pkg/jsonmap"
+	"log"
+	"os"
+	"time"
+)
+
+// RetentionProps contain the retention settings, a zero max age disables the corresponding purge
+type RetentionProps struct {
+	// Interval between two retention runs
+	Interval time.Duration
+	// SagaMaxAge terminal sagas (COMPLETED/ABORTED) not updated since are moved to the archive
+	SagaMaxAge time.Duration
+	// EventLogMaxAge consumed events older than the kafka topics retention can't be redelivered anymore
+	EventLogMaxAge time.Duration
+	// OutboxMaxAge outbox rows are captured by debezium from the WAL, once captured the rows are useless
+	OutboxMaxAge time.Duration
+	// KeepUnpublished the outbox rows not published yet by the relay (published_on) are not pruned, e.g. during
+	// a Kafka outage, set when the relay publishes the outbox instead of debezium
+	KeepUnpublished bool
+	// ArchiveFile the sagas are archived as JSON lines into this file, or into the sagastate_archive table when empty
+	ArchiveFile string
+	// BatchSize the max number of rows deleted per statement
+	BatchSize int
+}
+
+// Retention archives and purges the infrastructure tables (sagastate, eventlog, outboxevent)
+type Retention struct {
+	store *Store
+	props RetentionProps
+}
+
+// NewRetention constructor
+func NewRetention(store *Store, props RetentionProps) *Retention {
+	if props.BatchSize <= 0 {
+		props.BatchSize = 1000
+	}
+	return &Retention{store, props}
+}
+
+// Start runs the retention periodically until the context is done
+func (r *Retention) Start(ctx context.Context) error {
+	if r.props.Interval <= 0 {
+		return errors.New("retention interval must be positive")
+	}
+
+	ticker := time.NewTicker(r.props.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := r.Run(ctx); err != nil {
+			log.Printf("Failed to run retention: %v", err)
+		}
+	}
+}
+
+// Run archives the terminal sagas, purges the event log and prunes the outbox once
+func (r *Retention) Run(ctx context.Context) error {
+	now := time.Now()
+
+	if r.props.SagaMaxAge > 0 {
+		n, err := r.batched(ctx, func(tx *sql.Tx) (int64, error) {
+			return r.archiveSagas(ctx, tx, now.Add(-r.props.SagaMaxAge))
+		})
+		if err != nil {
+			return fmt.Errorf("archive sagas: %w", err)
+		}
+		log.Printf("Retention archived %d sagas", n)
+	}
+
+	if r.props.EventLogMaxAge > 0 {
+		q := `DELETE FROM eventlog WHERE (consumer_group, event_id) IN
+			(SELECT consumer_group, event_id FROM eventlog WHERE issued_on < $1 LIMIT $2)`
+		n, err := r.batched(ctx, r.delete(ctx, q, now.Add(-r.props.EventLogMaxAge)))
+		if err != nil {
+			return fmt.Errorf("purge event log: %w", err)
+		}
+		log.Printf("Retention purged %d event log entries", n)
+	}
+
+	if r.props.OutboxMaxAge > 0 {
+		q := "DELETE FROM outboxevent WHERE id IN (SELECT id FROM outboxevent WHERE timestamp < $1 LIMIT $2)"
+		if r.props.KeepUnpublished {
+			q = `DELETE FROM outboxevent WHERE id IN
+				(SELECT id FROM outboxevent WHERE timestamp < $1 AND published_on IS NOT NULL LIMIT $2)`
+		}
+		n, err := r.batched(ctx, r.delete(ctx, q, now.Add(-r.props.OutboxMaxAge)))
+		if err != nil {
+			return fmt.Errorf("prune outbox: %w", err)
+		}
+		log.Printf("Retention pruned %d outbox events", n)
+	}
+
+	return nil
+}
+
+// batched runs f in its own TX until a batch affects fewer rows than the batch size
+func (r *Retention) batched(ctx context.Context, f func(tx *sql.Tx) (int64, error)) (int64, error) {
+	var total int64
+	for {
+		n, err := r.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+			return f(tx)
+		})
+		if err != nil {
+			return total, err
+		}
+
+		total += n.(int64)
+		if n.(int64) < int64(r.props.BatchSize) {
+			return total, nil
+		}
+	}
+}
+
+func (r *Retention) delete(ctx context.Context, query string, before time.Time) func(tx *sql.Tx) (int64, error) {
+	return func(tx *sql.Tx) (int64, error) {
+		res, err := tx.ExecContext(ctx, query, before, r.props.BatchSize)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+}
+
+// archiveSagas moves a batch of terminal root sagas, and their child sagas, to the archive
+func (r *Retention) archiveSagas(ctx context.Context, tx *sql.Tx, before time.Time) (int64, error) {
+	// child sagas are archived along with their root saga, a running parent still needs its children
+	q := `WITH RECURSIVE tree AS (
+		(SELECT id FROM sagastate
+		WHERE parent_id IS NULL AND saga_status IN ($1, $2) AND updated_on < $3
+		LIMIT $4)
+		UNION ALL
+		SELECT s.id FROM sagastate s JOIN tree t ON s.parent_id = t.id
+	)
+	DELETE FROM sagastate WHERE id IN (SELECT id FROM tree)
+	RETURNING ` + archiveColumns
+
+	rows, err := tx.QueryContext(ctx, q, "COMPLETED", "ABORTED", before, r.props.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var archived []archivedSaga
+	for rows.Next() {
+		var a archivedSaga
+		if err := rows.Scan(&a.ID, &a.ParentID, &a.Version, &a.Type, &a.Payload, &a.CurrentStep, &a.StepStatus, &a.SagaStatus, &a.StepTimes, &a.StartedOn, &a.EndedOn, &a.UpdatedOn); err != nil {
+			return 0, err
+		}
+		archived = append(archived, a)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if r.props.ArchiveFile != "" {
+		return int64(len(archived)), r.archiveToFile(archived)
+	}
+
+	for _, a := range archived {
+		q := "INSERT INTO sagastate_archive(" + archiveColumns + ") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)"
+		if _, err := tx.ExecContext(ctx, q, a.ID, a.ParentID, a.Version, a.Type, a.Payload, a.CurrentStep, a.StepStatus, a.SagaStatus, a.StepTimes, a.StartedOn, a.EndedOn, a.UpdatedOn); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(archived)), nil
+}
+
+// archiveToFile appends the archived sagas as JSON lines, written before the TX commits (at least once)
+func (r *Retention) archiveToFile(archived []archivedSaga) error {
+	if len(archived) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(r.props.ArchiveFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, a := range archived {
+		if err := enc.Encode(a); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+const archiveColumns = "id, parent_id, version, type, payload, current_step, step_status, saga_status, step_times, started_on, ended_on, updated_on"
+
+// archivedSaga the sagastate row as archived
+type archivedSaga struct {
+	ID          string          `json:"id"`
+	ParentID    *string         `json:"parentId"`
+	Version     int64           `json:"version"`
+	Type        string          `json:"type"`
+	Payload     jsonmap.JSONMap `json:"payload"`
+	CurrentStep string          `json:"currentStep"`
+	StepStatus  jsonmap.JSONMap `json:"stepStatus"`
+	SagaStatus  string          `json:"sagaStatus"`
+	StepTimes   jsonmap.JSONMap `json:"stepTimes"`
+	StartedOn   time.Time       `json:"startedOn"`
+	EndedOn     *time.Time      `json:"endedOn"`
+	UpdatedOn   time.Time       `json:"updatedOn"`
+}
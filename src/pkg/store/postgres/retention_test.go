@@ -0,0 +1,69 @@
+package postgres_test
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go.example/saga/pkg/store/postgres"
+	"testing"
+	"time"
+)
+
+func TestRetentionArchivesTerminalSagaTrees(t *testing.T) {
+	st := testStore(t)
+
+	old := time.Now().Add(-48 * time.Hour)
+	insertSaga := func(parentID *uuid.UUID, status string, updatedOn time.Time) uuid.UUID {
+		id := uuid.New()
+		exec(t, st, `INSERT INTO sagastate(id, parent_id, version, type, payload, current_step, step_status, saga_status, updated_on)
+			VALUES ($1, $2, 1, 'test', '{}', '', '{}', $3, $4)`, id, parentID, status, updatedOn)
+		return id
+	}
+
+	// 3 archived trees over 2 batches, the running and the recent sagas are kept
+	for i := 0; i < 3; i++ {
+		root := insertSaga(nil, "COMPLETED", old)
+		insertSaga(&root, "COMPLETED", old)
+		insertSaga(&root, "ABORTED", old)
+	}
+	running := insertSaga(nil, "STARTED", old)
+	insertSaga(&running, "COMPLETED", old)
+	insertSaga(nil, "ABORTED", time.Now())
+
+	r := postgres.NewRetention(st, postgres.RetentionProps{SagaMaxAge: 24 * time.Hour, BatchSize: 2})
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(t, st, "SELECT count(*) FROM sagastate_archive"); n != 9 {
+		t.Errorf("archived %d sagas, want 9", n)
+	}
+	if n := count(t, st, "SELECT count(*) FROM sagastate"); n != 3 {
+		t.Errorf("kept %d sagas, want 3", n)
+	}
+	if n := count(t, st, "SELECT count(*) FROM sagastate WHERE parent_id = $1", running); n != 1 {
+		t.Errorf("kept %d children of the running saga, want 1", n)
+	}
+}
+
+func TestRetentionKeepsUnpublishedOutboxEvents(t *testing.T) {
+	st := testStore(t)
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, publishedOn := range []*time.Time{&old, nil} {
+		e := postgres.NewEvent(uuid.NewString(), "test", "TEST", nil)
+		exec(t, st, `INSERT INTO outboxevent(id, timestamp, aggregatetype, aggregateid, type, payload, published_on)
+			VALUES ($1, $2, $3, $4, $5, '{}', $6)`, e.ID, old, e.AggregateType, e.AggregateID, e.Type, publishedOn)
+	}
+
+	r := postgres.NewRetention(st, postgres.RetentionProps{OutboxMaxAge: time.Hour, KeepUnpublished: true})
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(t, st, "SELECT count(*) FROM outboxevent WHERE published_on IS NULL"); n != 1 {
+		t.Errorf("kept %d unpublished events, want 1", n)
+	}
+	if n := count(t, st, "SELECT count(*) FROM outboxevent"); n != 1 {
+		t.Errorf("kept %d events, want the unpublished one", n)
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"go.example/saga/pkg/saga"
 	"log"
+	"time"
 )
 
+const sagaColumns = "id, parent_id, version, type, payload, current_step, step_status, saga_status, deadline, step_times, started_on, ended_on"
+
 type SagaRepository struct {
 }
 
@@ -16,21 +20,34 @@ func NewSagaRepository() *SagaRepository {
 }
 
 func (sr SagaRepository) Persist(ctx context.Context, tx *sql.Tx, ss saga.SagaState) error {
-	qss := "INSERT INTO sagastate(id, version, type, payload, current_step, step_status, saga_status) VALUES ($1,$2,$3,$4,$5,$6,$7)"
-	_, err := tx.ExecContext(ctx, qss, ss.ID, ss.Version, ss.Type, ss.Payload, ss.CurrentStep, ss.StepStatus, ss.SagaStatus)
+	qss := "INSERT INTO sagastate(" + sagaColumns + ") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)"
+	_, err := tx.ExecContext(ctx, qss, ss.ID, ss.ParentID, ss.Version, ss.Type, ss.Payload, ss.CurrentStep, ss.StepStatus, ss.SagaStatus, ss.Deadline, ss.StepTimes, ss.StartedOn, ss.EndedOn)
 	return err
 }
 
+// Update the saga state if its version is still the one read (ss.Version-1), saga.ErrConcurrentUpdate otherwise
 func (sr SagaRepository) Update(ctx context.Context, tx *sql.Tx, ss saga.SagaState) error {
-	q := "UPDATE sagastate SET version=$1, payload=$2, current_step=$3, step_status=$4, saga_status=$5 WHERE id=$6"
-	_, err := tx.ExecContext(ctx, q, ss.Version, ss.Payload, ss.CurrentStep, ss.StepStatus, ss.SagaStatus, ss.ID)
-	return err
+	q := `UPDATE sagastate SET version=$1, payload=$2, current_step=$3, step_status=$4, saga_status=$5, deadline=$6,
+		step_times=$7, ended_on=$8, updated_on=CURRENT_TIMESTAMP WHERE id=$9 AND version=$10`
+	res, err := tx.ExecContext(ctx, q, ss.Version, ss.Payload, ss.CurrentStep, ss.StepStatus, ss.SagaStatus, ss.Deadline, ss.StepTimes, ss.EndedOn, ss.ID, ss.Version-1)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: saga %s version %d", saga.ErrConcurrentUpdate, ss.ID, ss.Version-1)
+	}
+	return nil
 }
 
+// QueryByID fetch the saga state and lock it until the TX ends, concurrent orchestrators processing the same saga
+// (e.g. a parent saga notified by two children) are serialized
 func (sr SagaRepository) QueryByID(ctx context.Context, tx *sql.Tx, ID string) (*saga.SagaState, error) {
 	var ss saga.SagaState
-	row := tx.QueryRowContext(ctx, "SELECT id, version, type, payload, current_step, step_status, saga_status FROM sagastate WHERE id=$1", ID)
-	err := row.Scan(&ss.ID, &ss.Version, &ss.Type, &ss.Payload, &ss.CurrentStep, &ss.StepStatus, &ss.SagaStatus)
+	row := tx.QueryRowContext(ctx, "SELECT "+sagaColumns+" FROM sagastate WHERE id=$1 FOR UPDATE", ID)
+	err := scanSaga(row, &ss)
 	if err != nil || errors.Is(err, sql.ErrNoRows) {
 		log.Printf("failed to fetch saga state %v", err)
 		return nil, err
@@ -38,3 +55,40 @@ func (sr SagaRepository) QueryByID(ctx context.Context, tx *sql.Tx, ID string) (
 
 	return &ss, nil
 }
+
+// QueryChildren fetch the sagas started by the provided parent saga
+func (sr SagaRepository) QueryChildren(ctx context.Context, tx *sql.Tx, parentID string) ([]saga.SagaState, error) {
+	return querySagas(ctx, tx, "SELECT "+sagaColumns+" FROM sagastate WHERE parent_id=$1 ORDER BY id", parentID)
+}
+
+// QueryExpired fetch the sagas awaiting a signal past their deadline, the rows are locked for the current TX
+func (sr SagaRepository) QueryExpired(ctx context.Context, tx *sql.Tx, now time.Time) ([]saga.SagaState, error) {
+	return querySagas(ctx, tx, "SELECT "+sagaColumns+" FROM sagastate WHERE deadline < $1 FOR UPDATE SKIP LOCKED", now)
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSaga(row scanner, ss *saga.SagaState) error {
+	return row.Scan(&ss.ID, &ss.ParentID, &ss.Version, &ss.Type, &ss.Payload, &ss.CurrentStep, &ss.StepStatus, &ss.SagaStatus, &ss.Deadline, &ss.StepTimes, &ss.StartedOn, &ss.EndedOn)
+}
+
+func querySagas(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]saga.SagaState, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sagas []saga.SagaState
+	for rows.Next() {
+		var ss saga.SagaState
+		if err := scanSaga(rows, &ss); err != nil {
+			return nil, err
+		}
+		sagas = append(sagas, ss)
+	}
+
+	return sagas, rows.Err()
+}
@@ -0,0 +1,142 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go.example/saga/pkg/jsonmap"
+	"go.example/saga/pkg/saga"
+	"go.example/saga/pkg/store/postgres"
+	"sync"
+	"testing"
+)
+
+var raceSaga = saga.Definition{
+	Type: "race",
+	Steps: []saga.Step{
+		{Name: "first", Kind: saga.StepKindParticipant},
+		{Name: "second", Kind: saga.StepKindParticipant},
+	},
+}
+
+// newOrchestrator an orchestrator instance with its own repository and publisher, as a replica would have
+func newOrchestrator() *saga.Orchestrator {
+	return saga.NewOrchestrator(postgres.NewSagaRepository(), postgres.NewOutboxPublisher(), nil, nil, raceSaga)
+}
+
+func startRaceSaga(t *testing.T, st *postgres.Store) string {
+	t.Helper()
+	state, err := postgres.Transact(context.Background(), st, postgres.TxOptions{}, func(tx *sql.Tx) (*saga.SagaState, error) {
+		return newOrchestrator().Start(context.Background(), tx, raceSaga.Type, jsonmap.JSONMap{"n": 1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state.ID.String()
+}
+
+func TestOrchestratorsRacingOnSameSagaOnlyOneWins(t *testing.T) {
+	st := testStore(t)
+	sagaID := startRaceSaga(t, st)
+
+	// the same reply redelivered to every orchestrator instance at once
+	const instances = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func(o *saga.Orchestrator) {
+			defer wg.Done()
+			_, err := st.Transact(context.Background(), func(tx *sql.Tx) (interface{}, error) {
+				return o.OnStepEvent(context.Background(), tx, sagaID, "first", saga.SagaStepStatusSucceeded)
+			})
+			errs <- err
+		}(newOrchestrator())
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("OnStepEvent: %v", err)
+		}
+	}
+	if n := count(t, st, "SELECT version FROM sagastate WHERE id = $1", sagaID); n != 2 {
+		t.Errorf("saga version %d, want 2 (a single transition)", n)
+	}
+	if n := count(t, st, "SELECT count(*) FROM outboxevent WHERE aggregateid = $1 AND aggregatetype = 'second'", sagaID); n != 1 {
+		t.Errorf("second step requested %d times, want once", n)
+	}
+}
+
+func TestStaleSagaUpdateIsConcurrentUpdateAndRetried(t *testing.T) {
+	st := testStore(t)
+	sagaID := startRaceSaga(t, st)
+	ctx := context.Background()
+	repository := postgres.NewSagaRepository()
+
+	stale, err := postgres.Transact(ctx, st, postgres.TxOptions{}, func(tx *sql.Tx) (*saga.SagaState, error) {
+		return repository.QueryByID(ctx, tx, sagaID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another orchestrator moves the saga meanwhile
+	if _, err := st.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		return newOrchestrator().OnStepEvent(ctx, tx, sagaID, "first", saga.SagaStepStatusSucceeded)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale version is rejected, the TX is retried and reads the current version
+	attempts := 0
+	if _, err := st.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		attempts++
+		state := stale
+		if attempts > 1 {
+			if state, err = repository.QueryByID(ctx, tx, sagaID); err != nil {
+				return nil, err
+			}
+		}
+		state.IncrementVersion()
+		err := repository.Update(ctx, tx, *state)
+		if attempts == 1 && !errors.Is(err, saga.ErrConcurrentUpdate) {
+			t.Errorf("stale update error %v, want saga.ErrConcurrentUpdate", err)
+		}
+		return nil, err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 2 {
+		t.Errorf("TX attempted %d times, want 2", attempts)
+	}
+	if n := count(t, st, "SELECT version FROM sagastate WHERE id = $1", sagaID); n != 3 {
+		t.Errorf("saga version %d, want 3", n)
+	}
+}
+
+func TestSagaVersionAboveInt8Range(t *testing.T) {
+	st := testStore(t)
+	sagaID := startRaceSaga(t, st)
+	ctx := context.Background()
+	repository := postgres.NewSagaRepository()
+	exec(t, st, "UPDATE sagastate SET version = 127 WHERE id = $1", sagaID)
+
+	if _, err := st.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		return newOrchestrator().OnStepEvent(ctx, tx, sagaID, "first", saga.SagaStepStatusSucceeded)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := postgres.Transact(ctx, st, postgres.TxOptions{}, func(tx *sql.Tx) (*saga.SagaState, error) {
+		return repository.QueryByID(ctx, tx, sagaID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != 128 {
+		t.Errorf("saga version %d, want 128", state.Version)
+	}
+}
@@ -0,0 +1,154 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"sort"
+)
+
+// concurrency slot statuses
+const (
+	slotStatusActive  = "ACTIVE"
+	slotStatusPending = "PENDING"
+)
+
+// SagaSlots defines the data access type for the saga concurrency slots, a saga holds one slot per limit applying
+// to it (e.g. saga type, hotel) and the sagas over the limit are pending in FIFO order
+type SagaSlots struct {
+}
+
+// NewSagaSlots constructor
+func NewSagaSlots() *SagaSlots {
+	return &SagaSlots{}
+}
+
+// Acquire takes a slot of every named limit for the saga, or queues the saga as pending, returns whether acquired
+func (ss SagaSlots) Acquire(ctx context.Context, tx *sql.Tx, sagaID string, limits map[string]int) (bool, error) {
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	if err := lockSlots(ctx, tx, names); err != nil {
+		return false, err
+	}
+
+	// the sagas already pending on a limit go first
+	acquired := true
+	for _, name := range names {
+		var active, pending int
+		q := "SELECT count(*) FILTER (WHERE status=$2), count(*) FILTER (WHERE status=$3) FROM sagaslot WHERE name=$1"
+		if err := tx.QueryRowContext(ctx, q, name, slotStatusActive, slotStatusPending).Scan(&active, &pending); err != nil {
+			return false, err
+		}
+		if active >= limits[name] || pending > 0 {
+			acquired = false
+		}
+	}
+
+	status := slotStatusActive
+	if !acquired {
+		status = slotStatusPending
+	}
+	for _, name := range names {
+		q := "INSERT INTO sagaslot(name, saga_id, max_active, status) VALUES ($1,$2,$3,$4)"
+		if _, err := tx.ExecContext(ctx, q, name, sagaID, limits[name], status); err != nil {
+			return false, err
+		}
+	}
+	return acquired, nil
+}
+
+// Release frees the saga slots, grants them to the oldest pending sagas and returns the sagas granted
+func (ss SagaSlots) Release(ctx context.Context, tx *sql.Tx, sagaID string) ([]string, error) {
+	released, err := queryStrings(ctx, tx, "DELETE FROM sagaslot WHERE saga_id=$1 RETURNING name", sagaID)
+	if err != nil || len(released) == 0 {
+		return nil, err
+	}
+	if err := lockSlots(ctx, tx, released); err != nil {
+		return nil, err
+	}
+
+	q := `SELECT saga_id FROM sagaslot WHERE name = ANY($1) AND status=$2
+		GROUP BY saga_id ORDER BY min(requested_on)`
+	pending, err := queryStrings(ctx, tx, q, pq.Array(released), slotStatusPending)
+	if err != nil {
+		return nil, err
+	}
+
+	// a pending saga blocked on a limit keeps the younger ones on the same limit pending (FIFO)
+	blocked := map[string]bool{}
+	var granted []string
+	for _, id := range pending {
+		names, free, err := ss.pendingSlots(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			free = free && !blocked[name]
+		}
+		if !free {
+			for _, name := range names {
+				blocked[name] = true
+			}
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE sagaslot SET status=$1 WHERE saga_id=$2", slotStatusActive, id); err != nil {
+			return nil, err
+		}
+		granted = append(granted, id)
+	}
+	return granted, nil
+}
+
+// pendingSlots returns the limits the saga is pending on and whether all of them have a free slot,
+// the limits of the saga not released yet are locked too before being counted
+func (ss SagaSlots) pendingSlots(ctx context.Context, tx *sql.Tx, sagaID string) ([]string, bool, error) {
+	names, err := queryStrings(ctx, tx, "SELECT name FROM sagaslot WHERE saga_id=$1", sagaID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := lockSlots(ctx, tx, names); err != nil {
+		return nil, false, err
+	}
+
+	var full int
+	q := `SELECT count(*) FROM sagaslot s
+		WHERE s.saga_id=$1 AND s.max_active <= (SELECT count(*) FROM sagaslot a WHERE a.name=s.name AND a.status=$2)`
+	if err := tx.QueryRowContext(ctx, q, sagaID, slotStatusActive).Scan(&full); err != nil {
+		return nil, false, err
+	}
+	return names, full == 0, nil
+}
+
+// lockSlots serializes the TXs counting the slots of the same limits until the current TX ends,
+// the limits are locked in order to prevent deadlocks
+func lockSlots(ctx context.Context, tx *sql.Tx, names []string) error {
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
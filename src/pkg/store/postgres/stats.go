@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"go.example/saga/pkg/saga"
+)
+
+// QueryStats computes the sagas outcome and duration statistics of the sagas still in the sagastate table
+func (sr SagaRepository) QueryStats(ctx context.Context, tx *sql.Tx) (*saga.Stats, error) {
+	stats := saga.Stats{Sagas: map[string]int{}}
+
+	rows, err := tx.QueryContext(ctx, "SELECT saga_status, count(*) FROM sagastate GROUP BY saga_status")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		stats.Sagas[status] = n
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var ended, compensated int
+	q := `SELECT count(*), count(*) FILTER (WHERE EXISTS (SELECT 1 FROM jsonb_each_text(step_status) s WHERE s.value=$1))
+		FROM sagastate WHERE ended_on IS NOT NULL`
+	if err := tx.QueryRowContext(ctx, q, saga.SagaStepStatusCompensated).Scan(&ended, &compensated); err != nil {
+		return nil, err
+	}
+	if ended > 0 {
+		stats.CompensationRate = float64(compensated) / float64(ended)
+	}
+
+	// durations in milliseconds
+	q = `SELECT type, '', count(*),
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY d),
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY d),
+			percentile_cont(0.99) WITHIN GROUP (ORDER BY d)
+		FROM (SELECT type, EXTRACT(EPOCH FROM ended_on - started_on) * 1000 AS d FROM sagastate WHERE ended_on IS NOT NULL) s
+		GROUP BY type ORDER BY type`
+	if stats.Durations, err = queryDurations(ctx, tx, q); err != nil {
+		return nil, err
+	}
+
+	q = `SELECT type, step, count(*),
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY d),
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY d),
+			percentile_cont(0.99) WITHIN GROUP (ORDER BY d)
+		FROM (SELECT s.type, t.key AS step,
+				EXTRACT(EPOCH FROM (t.value->>'endedOn')::timestamptz - (t.value->>'startedOn')::timestamptz) * 1000 AS d
+			FROM sagastate s, jsonb_each(s.step_times) t
+			WHERE t.value->>'startedOn' IS NOT NULL AND t.value->>'endedOn' IS NOT NULL) s
+		GROUP BY type, step ORDER BY type, step`
+	if stats.Steps, err = queryDurations(ctx, tx, q); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+func queryDurations(ctx context.Context, tx *sql.Tx, query string) ([]saga.DurationStats, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	durations := []saga.DurationStats{}
+	for rows.Next() {
+		var d saga.DurationStats
+		if err := rows.Scan(&d.Type, &d.Step, &d.Count, &d.P50, &d.P95, &d.P99); err != nil {
+			return nil, err
+		}
+		durations = append(durations, d)
+	}
+	return durations, rows.Err()
+}
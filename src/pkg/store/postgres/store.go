@@ -3,10 +3,12 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/lib/pq"
+	"go.example/saga/pkg/saga"
 	"log"
-
-	_ "github.com/lib/pq" // load PG driver
+	"time"
 )
 
 // StoreProps contain the postgres settings
@@ -16,30 +18,90 @@ type StoreProps struct {
 	User     string
 	Password string
 	Dbname   string
+	// Schema the schema the tables are created and queried in (search_path), the server default when empty
+	Schema string
 }
 
 type Store struct {
 	conn *sql.DB
+	// url the connection string, used by the dedicated LISTEN connections
+	url string
 }
 
 // NewStore constructor
 func NewStore(sp StoreProps) (*Store, error) {
 	psqlURL := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		sp.Host, sp.Port, sp.User, sp.Password, sp.Dbname)
+	if sp.Schema != "" {
+		psqlURL += " search_path=" + sp.Schema
+	}
 
 	db, err := sql.Open("postgres", psqlURL)
 	if err != nil || db.Ping() != nil {
 		log.Fatalf("failed to connect to database %v", err)
 		return nil, err
 	}
-	return &Store{conn: db}, nil
+	return &Store{conn: db, url: psqlURL}, nil
+}
+
+// Close closes the connection pool
+func (s *Store) Close() error {
+	return s.conn.Close()
 }
 
+// TxOptions defines the TX settings, the zero value is a read-write TX with the default isolation level
+type TxOptions struct {
+	// Isolation the TX isolation level, sql.LevelDefault (postgres READ COMMITTED) when zero
+	Isolation sql.IsolationLevel
+	// ReadOnly rejects the writes (and the row locks) within the TX
+	ReadOnly bool
+	// StatementTimeout aborts the TX statements running longer, the server setting applies when zero
+	StatementTimeout time.Duration
+	// DryRun rolls the TX back instead of committing it, e.g. planning what a TX would write
+	DryRun bool
+}
+
+var (
+	// ReadOnlyTx options of the TXs only querying
+	ReadOnlyTx = TxOptions{ReadOnly: true}
+	// SerializableTx options of the TXs updating the sagas, serialization failures are retried
+	SerializableTx = TxOptions{Isolation: sql.LevelSerializable}
+)
+
+// maxTransactRetries the number of times a TX is retried on a retryable error
+const maxTransactRetries = 3
+
+// Transact runs f within a TX and commits it, the TX is retried on serialization failures, deadlocks and
+// saga concurrent updates, f must not have side effects outside the TX
 func (s Store) Transact(ctx context.Context, f func(tx *sql.Tx) (interface{}, error)) (interface{}, error) {
-	tx, e := s.conn.BeginTx(ctx, nil)
+	return Transact(ctx, &s, TxOptions{}, f)
+}
+
+// Transact runs f within a TX of the provided options and commits it, returning the typed result of f,
+// the TX is retried as Store.Transact does
+func Transact[T any](ctx context.Context, s *Store, opts TxOptions, f func(tx *sql.Tx) (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		val, err := transact(ctx, s, opts, f)
+		if err == nil || attempt == maxTransactRetries || !retryable(err) {
+			return val, err
+		}
+
+		log.Printf("Retrying transaction (attempt %d) after %v", attempt+1, err)
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 50 * time.Millisecond):
+		}
+	}
+}
+
+func transact[T any](ctx context.Context, s *Store, opts TxOptions, f func(tx *sql.Tx) (T, error)) (T, error) {
+	var zero T
+	tx, e := s.conn.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
 	// Any error here is non-retryable
 	if e != nil {
-		return nil, e
+		return zero, e
 	}
 
 	// Defer a rollback in case anything fails.
@@ -48,42 +110,41 @@ func (s Store) Transact(ctx context.Context, f func(tx *sql.Tx) (interface{}, er
 		_ = tx.Rollback()
 	}()
 
+	// the timeout is reset once the TX ends
+	if opts.StatementTimeout > 0 {
+		q := fmt.Sprintf("SET LOCAL statement_timeout = %d", opts.StatementTimeout.Milliseconds())
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return zero, err
+		}
+	}
+
 	val, err := f(tx)
 
 	if err != nil {
-		return nil, err
+		return zero, err
+	}
+	if opts.DryRun {
+		return val, nil
 	}
 
 	// Commit the transaction.
 	if err = tx.Commit(); err != nil {
-		return nil, err
+		return zero, err
 	}
 
-	return val, nil //TODO use retry and Optimistic Locking
+	return val, nil
 }
 
-/*
-func retryable(wrapped func() (interface{}, error), onError func(Error) FutureNil) (ret interface{}, e error) {
-	for {
-		ret, e = wrapped()
-
-		// No error means success!
-		if e == nil {
-			return
-		}
-
-		// Check if the error chain contains an
-		// fdb.Error
-		var ep Error
-		if errors.As(e, &ep) {
-			e = onError(ep).Get()
-		}
+// retryable reports whether the TX failed due to a concurrent TX and can be retried
+func retryable(err error) bool {
+	if errors.Is(err, saga.ErrConcurrentUpdate) {
+		return true
+	}
 
-		// If OnError returns an error, then it's not
-		// retryable; otherwise take another pass at things
-		if e != nil {
-			return
-		}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization_failure, deadlock_detected
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
 	}
+	return false
 }
-*/
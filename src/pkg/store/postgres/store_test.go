@@ -0,0 +1,40 @@
+package postgres_test
+
+import (
+	"go.example/saga/pkg/store/postgres"
+	"go.example/saga/pkg/store/postgres/postgrestest"
+	"go.example/saga/reservation/schema"
+	"os"
+	"testing"
+)
+
+// testStore the store of a schema dedicated to the test, migrated with the reservation schema, in the database of
+// the POSTGRES_HOST env (e.g. the docker compose reservation-db), the test is skipped when POSTGRES_HOST is not set
+func testStore(t testing.TB) *postgres.Store {
+	t.Helper()
+	return postgrestest.NewStore(t, postgres.StoreProps{
+		Port:     env("POSTGRES_PORT", "5432"),
+		User:     env("POSTGRES_USER", "reservationuser"),
+		Password: env("POSTGRES_PASSWORD", "secret"),
+		Dbname:   env("POSTGRES_DB", "reservationdb"),
+	}, schema.Migrations())
+}
+
+// exec runs the statement in its own TX
+func exec(t testing.TB, st *postgres.Store, query string, args ...interface{}) {
+	t.Helper()
+	postgrestest.Exec(t, st, query, args...)
+}
+
+// count returns the single int result of the query
+func count(t testing.TB, st *postgres.Store, query string, args ...interface{}) int {
+	t.Helper()
+	return postgrestest.Count(t, st, query, args...)
+}
+
+func env(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
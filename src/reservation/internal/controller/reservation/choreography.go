@@ -0,0 +1,83 @@
+package reservation
+
+import (
+	"context"
+	"database/sql"
+	"go.example/saga/pkg/saga"
+	"go.example/saga/pkg/store/postgres"
+	"go.example/saga/reservation/pkg/model"
+	"log"
+)
+
+// reservationCreatedEventType starts the choreography, the hotel books the room on it
+const reservationCreatedEventType = "ReservationCreated"
+
+// createReservation create the reservation in PENDING state and publish the ReservationCreated event (choreography mode),
+// the hotel and payment services react to each other events, no saga is orchestrated.
+func (c *Controller) createReservation(ctx context.Context, tx *sql.Tx, r *model.Reservation) error {
+	if err := c.repository.Add(ctx, tx, r); err != nil {
+		return err
+	}
+
+	// the reservation ID is the key and the correlation ID of all the choreography events
+	headers := postgres.Headers{postgres.HeaderCorrelationID: r.ID.String()}
+	outboxEvent := postgres.NewEvent(r.ID.String(), roomBookingStep, reservationCreatedEventType, r.ToJSONMap(), headers)
+	if err := outboxEvent.Persist(ctx, tx); err != nil {
+		return err
+	}
+
+	log.Printf("Published ReservationCreated for reservationID %s", r.ID)
+	return nil
+}
+
+// onDomainEvent updates the reservation status from the participants domain events (choreography mode)
+func (c *Controller) onDomainEvent(ctx context.Context, groupID string, reservationID string, eventID string, step saga.SagaStep, status saga.SagaStepStatus) (interface{}, error) {
+	if _, ok := domainStatus(step, status); !ok {
+		return nil, nil
+	}
+
+	return c.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
+		if first, err := c.eventLogger.TryConsume(ctx, tx, groupID, eventID); err != nil || !first {
+			return nil, err
+		}
+
+		return nil, c.applyDomainEvent(ctx, tx, reservationID, step, status)
+	})
+}
+
+// planDomainEvents applies the scripted participant domain events to the reservation in their choreography order,
+// the room booked then the payment, a participant without scripted reply doesn't publish its event
+func (c *Controller) planDomainEvents(ctx context.Context, tx *sql.Tx, reservationID string, script saga.Script) error {
+	for _, step := range []saga.SagaStep{roomBookingStep, paymentStep} {
+		status, ok := script[string(step)]
+		if !ok {
+			return nil
+		}
+		if err := c.applyDomainEvent(ctx, tx, reservationID, step, status); err != nil || status != saga.SagaStepStatusSucceeded {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyDomainEvent updates the reservation status from the participant domain event
+func (c *Controller) applyDomainEvent(ctx context.Context, tx *sql.Tx, reservationID string, step saga.SagaStep, status saga.SagaStepStatus) error {
+	reservationStatus, ok := domainStatus(step, status)
+	if !ok {
+		return nil
+	}
+	return c.repository.UpdateStatus(ctx, tx, reservationID, reservationStatus)
+}
+
+// domainStatus the reservation status following a domain event: a rejected room or a failed payment fails
+// the reservation, a completed payment succeeds it, the other events don't change it
+func domainStatus(step saga.SagaStep, status saga.SagaStepStatus) (model.ReservationStatus, bool) {
+	switch {
+	case status == saga.SagaStepStatusFailed:
+		return model.ReservationStatusFailed, true
+	case step == paymentStep && status == saga.SagaStepStatusSucceeded:
+		return model.ReservationStatusSucceed, true
+	default:
+		return "", false
+	}
+}
@@ -3,27 +3,128 @@ package reservation
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"go.example/saga/pkg/jsonmap"
+	"go.example/saga/pkg/messaging"
 	"go.example/saga/pkg/saga"
 	"go.example/saga/pkg/store/postgres"
 	"go.example/saga/reservation/pkg/model"
 	"log"
+	"time"
 )
 
-const roomReservationSaga = "room-reservation"
+const (
+	roomReservationSaga          = "room-reservation"
+	tccRoomReservationSaga       = "room-reservation-tcc"
+	highValueRoomReservationSaga = "high-value-room-reservation"
+	groupReservationSaga         = "group-reservation"
+)
 
 const (
-	roomBookingStep = "room-booking"
-	paymentStep     = "payment"
+	roomBookingStep      = "room-booking"
+	managerApprovalStep  = "manager-approval"
+	paymentStep          = "payment"
+	roomReservationsStep = "room-reservations"
 )
 
-// sagaSteps provides the service order steps to complete a reservation(SUCCESS/FAILED)
-var sagaSteps = []saga.SagaStep{roomBookingStep, paymentStep}
+// Config defines the reservation sagas settings
+type Config struct {
+	// ApprovalThreshold reservations with a payment due from this amount wait for a manager approval, disabled when zero
+	ApprovalThreshold int64
+	// ApprovalTimeout aborts the reservation when the manager approval is not received in time, no timeout when zero
+	ApprovalTimeout time.Duration
+	// Mode orchestrates the reservation through sagas, or relies on the participants domain events in choreography mode
+	Mode saga.Mode
+	// Protocol the protocol used for room reservations, saga.ProtocolTCC holds the room and authorizes the payment
+	// before confirming both, saga.ProtocolSaga is used when empty
+	Protocol saga.Protocol
+	// RoomLockPolicy semantic lock policy isolating the reservations of the same room and start date, no lock when empty
+	RoomLockPolicy saga.LockPolicy
+	// RoomLockTimeout aborts the reservation waiting for the room lock (saga.LockPolicyWait) when not acquired in time
+	RoomLockTimeout time.Duration
+	// MaxActiveReservations caps the reservation sagas in progress, the others are pending, no limit when zero
+	MaxActiveReservations int
+	// MaxActivePerHotel caps the reservation sagas in progress per hotel, the others are pending, no limit when zero
+	MaxActivePerHotel int
+}
+
+// reservationLimits provides the concurrency limits of the room reservation sagas
+func reservationLimits(cfg Config) []saga.Limit {
+	var limits []saga.Limit
+	if cfg.MaxActiveReservations > 0 {
+		limits = append(limits, saga.Limit{Max: cfg.MaxActiveReservations})
+	}
+	if cfg.MaxActivePerHotel > 0 {
+		limits = append(limits, saga.Limit{Key: "hotelId", Max: cfg.MaxActivePerHotel})
+	}
+	return limits
+}
+
+// bookingStep provides the room booking step, locking the room for the reservation start date when configured
+func bookingStep(cfg Config) saga.Step {
+	step := saga.Step{Name: roomBookingStep, Kind: saga.StepKindParticipant}
+	if cfg.RoomLockPolicy != "" {
+		step.Lock = roomLock
+		step.LockPolicy = cfg.RoomLockPolicy
+		step.Timeout = cfg.RoomLockTimeout
+	}
+	return step
+}
+
+// roomLock names the semantic lock of the reserved room
+func roomLock(payload jsonmap.JSONMap) string {
+	return fmt.Sprintf("room:%v:%v", payload["roomId"], payload["startDate"])
+}
+
+// sagaDefinitions provides the service order steps to complete a reservation(SUCCESS/FAILED),
+// the reservation status is updated by the ended hook once its saga completes or aborts
+func sagaDefinitions(cfg Config, ended func(ctx context.Context, tx *sql.Tx, state saga.SagaState) error) []saga.Definition {
+	return []saga.Definition{
+		{
+			Type:   roomReservationSaga,
+			Limits: reservationLimits(cfg),
+			Ended:  ended,
+			Steps: []saga.Step{
+				bookingStep(cfg),
+				{Name: paymentStep, Kind: saga.StepKindParticipant},
+			},
+		},
+		{
+			// the room is held and the payment authorized, both are confirmed once tried successfully
+			Type:     tccRoomReservationSaga,
+			Protocol: saga.ProtocolTCC,
+			Limits:   reservationLimits(cfg),
+			Ended:    ended,
+			Steps: []saga.Step{
+				bookingStep(cfg),
+				{Name: paymentStep, Kind: saga.StepKindParticipant},
+			},
+		},
+		{
+			// the room is held while a manager approves the reservation, payment is requested once approved
+			Type:   highValueRoomReservationSaga,
+			Limits: reservationLimits(cfg),
+			Ended:  ended,
+			Steps: []saga.Step{
+				bookingStep(cfg),
+				{Name: managerApprovalStep, Kind: saga.StepKindSignal, Signal: approvalSignal, Timeout: cfg.ApprovalTimeout},
+				{Name: paymentStep, Kind: saga.StepKindParticipant},
+			},
+		},
+		{
+			// a group reservation starts one room reservation saga per room
+			Type: groupReservationSaga,
+			Steps: []saga.Step{
+				{Name: roomReservationsStep, Kind: saga.StepKindChildSaga, ChildSaga: roomReservationSaga, Children: reservationPayloads},
+			},
+		},
+	}
+}
 
 // eventLogger defines the interface for ensuring the exact once event consuming as part of current tx
 type eventLogger interface {
-	IsConsumed(ctx context.Context, tx *sql.Tx, eventID string) bool
-	Consume(ctx context.Context, tx *sql.Tx, eventID string) error
+	TryConsume(ctx context.Context, tx *sql.Tx, consumerGroup string, eventID string) (bool, error)
 }
 
 type repository interface {
@@ -33,27 +134,35 @@ type repository interface {
 }
 
 type ingester[T model.Payload] interface {
-	Ingest(ctx context.Context) (chan model.Event[T], error)
+	Consume(ctx context.Context, handler messaging.EventHandler[model.Event[T]]) error
 }
 
 // Controller defines a Reservation service controller.
 type Controller struct {
+	cfg             Config
 	store           *postgres.Store
 	eventLogger     eventLogger
 	repository      repository
 	sagaRepository  saga.Repository
+	orchestrator    *saga.Orchestrator
 	bookingIngester ingester[model.BookingEventPayload]
 	paymentIngester ingester[model.PaymentEventPayload]
 }
 
 // New creates a reservation service controller.
-func New(store *postgres.Store,
+func New(cfg Config,
+	store *postgres.Store,
 	eventLogger eventLogger,
 	repository repository,
 	sagaRepository saga.Repository,
+	publisher saga.Publisher,
+	locker saga.Locker,
+	limiter saga.Limiter,
 	bookingIngester ingester[model.BookingEventPayload],
 	paymentIngester ingester[model.PaymentEventPayload]) *Controller {
-	return &Controller{store, eventLogger, repository, sagaRepository, bookingIngester, paymentIngester}
+	c := &Controller{cfg, store, eventLogger, repository, sagaRepository, nil, bookingIngester, paymentIngester}
+	c.orchestrator = saga.NewOrchestrator(sagaRepository, publisher, locker, limiter, sagaDefinitions(cfg, c.updateReservationStatus)...)
+	return c
 }
 
 // PostReservation create the reservation in PENDING state and starts the saga process to complete the reservation
@@ -64,30 +173,7 @@ func (c *Controller) PostReservation(ctx context.Context, cmd model.ReservationC
 	// alert kafka using type events
 	// FIXME: implement properly transactional script pattern
 	if _, err := c.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
-
-		// persist reservation
-		if err := c.repository.Add(ctx, tx, r); err != nil {
-			return nil, err
-		}
-
-		// Start SAGA
-		payload := r.ToJSONMap()
-		currStep := saga.NextSagaStep(sagaSteps, "")
-		sagaState := saga.NewSaga(roomReservationSaga, payload, currStep)
-
-		if err := c.sagaRepository.Persist(ctx, tx, sagaState); err != nil {
-			return nil, err
-		}
-
-		// publish outbox event to debezium
-		outboxEvent := postgres.NewEvent(sagaState.ID.String(), string(currStep), postgres.RequestEventType, payload)
-		if err := outboxEvent.Persist(ctx, tx); err != nil {
-			return nil, err
-		}
-
-		log.Printf("Started Saga for reservationID %s sagaID %s", r.ID, sagaState.ID)
-
-		return r, nil
+		return c.reserve(ctx, tx, r)
 	}); err != nil {
 		return nil, err
 	}
@@ -95,146 +181,168 @@ func (c *Controller) PostReservation(ctx context.Context, cmd model.ReservationC
 	return r, nil
 }
 
-func (c Controller) GetReservation(ctx context.Context, ID string) (interface{}, error) {
-	r, err := c.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
-		r, err := c.repository.QueryByID(ctx, tx, ID)
-		return r, err
-	})
+// reserve persists the reservation in PENDING state and starts its saga, or publishes the ReservationCreated
+// event in choreography mode, returns the aggregate ID of the published events (saga ID, or reservation ID)
+func (c *Controller) reserve(ctx context.Context, tx *sql.Tx, r *model.Reservation) (string, error) {
+	if c.cfg.Mode == saga.ModeChoreography {
+		return r.ID.String(), c.createReservation(ctx, tx, r)
+	}
 
-	return r, err
-}
+	// persist reservation
+	if err := c.repository.Add(ctx, tx, r); err != nil {
+		return "", err
+	}
 
-// StartBookingIngestion starts the ingestion of room booking events.
-func (c *Controller) StartBookingIngestion(ctx context.Context) error {
-	// Ingest room booking events through the provided ingester.
-	ch, err := c.bookingIngester.Ingest(ctx)
+	// Start SAGA, the first step request is published to debezium through the outbox
+	sagaState, err := c.orchestrator.Start(ctx, tx, c.reservationSagaType(r), r.ToJSONMap())
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// Process each room booking event received from the ingester channel.
-	for e := range ch {
-		log.Printf("On RoomBookingEvent  key %s eventID %s payload %v", e.MsgID, e.EventID, e.Payload)
-		if _, err := c.onStepEvent(ctx, e.MsgID, e.EventID, e.Payload.SagaStepStatus()); err != nil {
-			log.Printf("Failed to process message key %s eventID %s: %v", e.MsgID, e.EventID, err)
-		}
-	}
-	return nil
+	log.Printf("Started Saga for reservationID %s sagaID %s", r.ID, sagaState.ID)
+	return sagaState.ID.String(), nil
 }
 
-// StartPaymentIngestion starts the ingestion of room booking events.
-func (c *Controller) StartPaymentIngestion(ctx context.Context) error {
-	// Ingest room booking events through the provided ingester.
-	ch, err := c.paymentIngester.Ingest(ctx)
-	if err != nil {
-		return err
-	}
+// PlanReservation dry runs the reservation against the scripted participant replies: the reservation is made as
+// PostReservation does within a TX rolled back, the plan returns the outbox events the reservation would publish
+func (c *Controller) PlanReservation(ctx context.Context, cmd model.DryRunReservationCmd) (*model.ReservationPlan, error) {
+	r := model.NewReservation(cmd.HotelID, cmd.RoomID, cmd.GuestID, cmd.PaymentDue, cmd.StartDate, cmd.EndDate, cmd.CreditCardNO)
 
-	// Process each room booking event received from the ingester channel.
-	for e := range ch {
-		log.Printf("On PaymentEvent key %s eventID %s payload %v", e.MsgID, e.EventID, e.Payload)
-		if _, err := c.onStepEvent(ctx, e.MsgID, e.EventID, e.Payload.SagaStepStatus()); err != nil {
-			log.Printf("Failed to process message key %s eventID %s: %v", e.MsgID, e.EventID, err)
-		}
+	script := make(saga.Script, len(cmd.Responses))
+	for step, status := range cmd.Responses {
+		script[step] = saga.SagaStepStatus(status)
 	}
-	return nil
-}
 
-// onStepEvent is invoked by the ingester on incoming event
-// in one transaction it ensures saga moving to next/prev status and update the reservation status
-func (c *Controller) onStepEvent(ctx context.Context, msgID string, eventID string, sagaStepStatus saga.SagaStepStatus) (interface{}, error) {
-	return c.store.Transact(ctx, func(tx *sql.Tx) (interface{}, error) {
-		// 1. check if already processed event
-		if c.eventLogger.IsConsumed(ctx, tx, eventID) {
-			return nil, nil
+	opts := postgres.TxOptions{DryRun: true}
+	return postgres.Transact(ctx, c.store, opts, func(tx *sql.Tx) (*model.ReservationPlan, error) {
+		aggregateID, err := c.reserve(ctx, tx, r)
+		if err != nil {
+			return nil, err
 		}
 
-		// 2. find the saga
-		state, err := c.sagaRepository.QueryByID(ctx, tx, msgID)
+		plan := &model.ReservationPlan{}
+		if c.cfg.Mode == saga.ModeChoreography {
+			err = c.planDomainEvents(ctx, tx, r.ID.String(), script)
+		} else {
+			plan.Saga, err = c.orchestrator.DryRun(ctx, tx, aggregateID, script, func() ([]saga.PlannedEvent, error) {
+				return plannedCommands(ctx, tx, aggregateID)
+			})
+		}
 		if err != nil {
-			//log.Printf("failed to fetch saga state %v", err)
-			return nil, nil
+			return nil, err
 		}
 
-		// 3. update sagaStepStatus.update existing
-		state.StepStatus[string(state.CurrentStep)] = sagaStepStatus
-
-		// 4. Check current sagaStep Status and decide
-		if sagaStepStatus == saga.SagaStepStatusSucceeded {
-			if err := advance(ctx, tx, state); err != nil {
-				return nil, err
-			}
-		} else if sagaStepStatus == saga.SagaStepStatusFailed || sagaStepStatus == saga.SagaStepStatusCompensated {
-			if err := goBack(ctx, tx, state); err != nil {
-				return nil, err
-			}
+		if plan.Events, err = postgres.QueryEvents(ctx, tx, aggregateID); err != nil {
+			return nil, err
+		}
+		if plan.Reservation, err = c.repository.QueryByID(ctx, tx, r.ID.String()); err != nil {
+			return nil, err
 		}
+		return plan, nil
+	})
+}
+
+// plannedCommands reads back the saga commands published to the outbox within the TX
+func plannedCommands(ctx context.Context, tx *sql.Tx, sagaID string) ([]saga.PlannedEvent, error) {
+	events, err := postgres.QueryEvents(ctx, tx, sagaID)
+	if err != nil {
+		return nil, err
+	}
 
-		// change sagaStatus
-		state.NextSagaStatus()
+	planned := make([]saga.PlannedEvent, 0, len(events))
+	for _, e := range events {
+		planned = append(planned, saga.PlannedEvent{ID: e.ID.String(), SagaID: e.AggregateID, Step: saga.SagaStep(e.AggregateType), Command: saga.Command(e.Type)})
+	}
+	return planned, nil
+}
 
-		// FIXME: use optimistic locking => change version
-		// FIXME: use sequence for versioning
-		state.IncrementVersion()
+// reservationSagaType selects the saga type completing the provided reservation
+func (c *Controller) reservationSagaType(r *model.Reservation) string {
+	if c.cfg.ApprovalThreshold > 0 && r.PaymentDue >= c.cfg.ApprovalThreshold {
+		return highValueRoomReservationSaga
+	}
+	if c.cfg.Protocol == saga.ProtocolTCC {
+		return tccRoomReservationSaga
+	}
+	return roomReservationSaga
+}
 
-		// Saga Update
-		if err := c.sagaRepository.Update(ctx, tx, *state); err != nil {
-			return nil, err
-		}
+func (c Controller) GetReservation(ctx context.Context, ID string) (*model.ReservationView, error) {
+	return postgres.Transact(ctx, c.store, postgres.ReadOnlyTx, func(tx *sql.Tx) (*model.ReservationView, error) {
+		return c.repository.QueryByID(ctx, tx, ID)
+	})
+}
 
-		// update reservation status
-		if err := c.updateReservationStatus(tx, *state, ctx); err != nil {
-			return nil, err
-		}
+// statsTimeout bounds the saga statistics aggregation over the sagastate table
+const statsTimeout = 10 * time.Second
 
-		// mark as consumed
-		err2 := c.eventLogger.Consume(ctx, tx, eventID)
-		return nil, err2
+// GetSagaStats computes the sagas outcome and duration statistics
+func (c *Controller) GetSagaStats(ctx context.Context) (*saga.Stats, error) {
+	opts := postgres.TxOptions{ReadOnly: true, StatementTimeout: statsTimeout}
+	return postgres.Transact(ctx, c.store, opts, func(tx *sql.Tx) (*saga.Stats, error) {
+		return c.sagaRepository.QueryStats(ctx, tx)
 	})
 }
 
-// advance move saga step to next step based on sagasteps and current step
-// generate a new request outbox event
-func advance(ctx context.Context, tx *sql.Tx, state *saga.SagaState) error {
-	nextState := saga.NextSagaStep(sagaSteps, state.CurrentStep)
-	if nextState == "" {
-		state.CurrentStep = ""
-	} else {
-		state.StepStatus[string(nextState)] = saga.SagaStepStatusStarted
-		state.CurrentStep = nextState
+// StartBookingIngestion starts the ingestion of room booking events, an event is acknowledged once its TX committed.
+func (c *Controller) StartBookingIngestion(ctx context.Context) error {
+	return c.bookingIngester.Consume(ctx, c.HandleRoomBooking)
+}
 
-		// Outbox insert
-		outboxEvent := postgres.NewEvent(state.ID.String(), string(nextState), postgres.RequestEventType, state.Payload)
-		if err := outboxEvent.Persist(ctx, tx); err != nil {
-			return err
-		}
+// StartPaymentIngestion starts the ingestion of payment events, an event is acknowledged once its TX committed.
+func (c *Controller) StartPaymentIngestion(ctx context.Context) error {
+	return c.paymentIngester.Consume(ctx, c.HandlePayment)
+}
+
+// HandleRoomBooking processes a room booking event, the event is redelivered on error
+func (c *Controller) HandleRoomBooking(ctx context.Context, e model.Event[model.BookingEventPayload]) error {
+	log.Printf("On RoomBookingEvent  key %s eventID %s payload %v", e.MsgID, e.EventID, e.Payload)
+	if _, err := c.onEvent(ctx, e.GroupID, e.MsgID, e.EventID, roomBookingStep, e.Payload.SagaStepStatus()); err != nil {
+		log.Printf("Failed to process message key %s eventID %s: %v", e.MsgID, e.EventID, err)
+		return err
 	}
 	return nil
 }
 
-// goBack move saga step to prev step based on sagasteps and current step
-// generate a compensating request outbox event
-func goBack(ctx context.Context, tx *sql.Tx, state *saga.SagaState) error {
-	prevState := saga.PrevSagaStep(sagaSteps, state.CurrentStep)
-	if prevState == "" {
-		state.CurrentStep = ""
-	} else {
-		state.StepStatus[string(prevState)] = saga.SagaStepStatusCompensating
-		state.CurrentStep = prevState
-
-		// Outbox insert
-		payload := state.Payload
-		payload["type"] = postgres.CancelEventType
-		outboxEvent := postgres.NewEvent(state.ID.String(), string(prevState), postgres.CancelEventType, state.Payload)
-		if err := outboxEvent.Persist(ctx, tx); err != nil {
-			return err
-		}
+// HandlePayment processes a payment event, the event is redelivered on error
+func (c *Controller) HandlePayment(ctx context.Context, e model.Event[model.PaymentEventPayload]) error {
+	log.Printf("On PaymentEvent key %s eventID %s payload %v", e.MsgID, e.EventID, e.Payload)
+	if _, err := c.onEvent(ctx, e.GroupID, e.MsgID, e.EventID, paymentStep, e.Payload.SagaStepStatus()); err != nil {
+		log.Printf("Failed to process message key %s eventID %s: %v", e.MsgID, e.EventID, err)
+		return err
 	}
 	return nil
 }
 
-// updateReservationStatus change the status of reservation baed on sagaState
-func (c *Controller) updateReservationStatus(tx *sql.Tx, state saga.SagaState, ctx context.Context) error {
+// onEvent dispatches the participant event to the saga orchestration, or to the choreography handler
+func (c *Controller) onEvent(ctx context.Context, groupID string, msgID string, eventID string, step saga.SagaStep, status saga.SagaStepStatus) (interface{}, error) {
+	if c.cfg.Mode == saga.ModeChoreography {
+		return c.onDomainEvent(ctx, groupID, msgID, eventID, step, status)
+	}
+	return c.onStepEvent(ctx, groupID, msgID, eventID, step, status)
+}
+
+// onStepEvent is invoked by the ingester on incoming event
+// in one transaction it ensures saga moving to next/prev status and update the reservation status (Definition.Ended)
+func (c *Controller) onStepEvent(ctx context.Context, groupID string, msgID string, eventID string, step saga.SagaStep, sagaStepStatus saga.SagaStepStatus) (interface{}, error) {
+	return postgres.Transact(ctx, c.store, postgres.SerializableTx, func(tx *sql.Tx) (interface{}, error) {
+		// 1. mark as consumed, unless already processed event
+		if first, err := c.eventLogger.TryConsume(ctx, tx, groupID, eventID); err != nil || !first {
+			return nil, err
+		}
+
+		// 2. move the saga to next/prev step, the reservation status is updated once the saga ended
+		_, err := c.orchestrator.OnStepEvent(ctx, tx, msgID, step, sagaStepStatus)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	})
+}
+
+// updateReservationStatus change the status of reservation baed on sagaState, the ended hook of the
+// room reservation sagas, including the ones started by a group reservation or begun once granted a slot
+func (c *Controller) updateReservationStatus(ctx context.Context, tx *sql.Tx, state saga.SagaState) error {
 	sagaID := fmt.Sprintf("%v", state.Payload["reservationId"])
 	if state.SagaStatus == saga.SagaStatusCompleted {
 		if err := c.repository.UpdateStatus(ctx, tx, sagaID, model.ReservationStatusSucceed); err != nil {
@@ -0,0 +1,99 @@
+package reservation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.example/saga/pkg/jsonmap"
+	"go.example/saga/pkg/saga"
+	"go.example/saga/pkg/store/postgres"
+	repo "go.example/saga/reservation/internal/repository"
+	"go.example/saga/reservation/pkg/model"
+	"log"
+)
+
+// PostGroupReservation create one PENDING reservation per room and starts the group reservation saga,
+// which starts one room reservation saga per room
+func (c *Controller) PostGroupReservation(ctx context.Context, cmd model.GroupReservationCmd) (*saga.SagaState, error) {
+	if len(cmd.RoomIDs) == 0 {
+		return nil, errors.New("group reservation requires at least one room")
+	}
+
+	return postgres.Transact(ctx, c.store, postgres.TxOptions{}, func(tx *sql.Tx) (*saga.SagaState, error) {
+		reservations := make([]interface{}, 0, len(cmd.RoomIDs))
+		for _, roomID := range cmd.RoomIDs {
+			r := model.NewReservation(cmd.HotelID, roomID, cmd.GuestID, cmd.PaymentDue, cmd.StartDate, cmd.EndDate, cmd.CreditCardNO)
+			if err := c.repository.Add(ctx, tx, r); err != nil {
+				return nil, err
+			}
+			reservations = append(reservations, r.ToJSONMap())
+		}
+
+		payload := jsonmap.JSONMap{
+			"hotelId":      cmd.HotelID,
+			"guestId":      cmd.GuestID,
+			"reservations": reservations,
+		}
+		sagaState, err := c.orchestrator.Start(ctx, tx, groupReservationSaga, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Started group reservation Saga %s for %d rooms", sagaState.ID, len(cmd.RoomIDs))
+		return sagaState, nil
+	})
+}
+
+// GetGroupReservation returns the group reservation saga status and its room reservations
+// the saga row is locked (SagaRepository.QueryByID), the TX cannot be read-only
+func (c *Controller) GetGroupReservation(ctx context.Context, ID string) (*model.GroupReservationView, error) {
+	if _, err := uuid.Parse(ID); err != nil {
+		return nil, repo.ErrNotFound
+	}
+
+	return postgres.Transact(ctx, c.store, postgres.TxOptions{}, func(tx *sql.Tx) (*model.GroupReservationView, error) {
+		state, err := c.sagaRepository.QueryByID(ctx, tx, ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repo.ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if state.Type != groupReservationSaga {
+			return nil, repo.ErrNotFound
+		}
+
+		children, err := c.sagaRepository.QueryChildren(ctx, tx, ID)
+		if err != nil {
+			return nil, err
+		}
+
+		view := &model.GroupReservationView{ID: state.ID, Status: state.SagaStatus}
+		for _, child := range children {
+			r, err := c.repository.QueryByID(ctx, tx, fmt.Sprintf("%v", child.Payload["reservationId"]))
+			if err != nil {
+				return nil, err
+			}
+			view.Reservations = append(view.Reservations, *r)
+		}
+		return view, nil
+	})
+}
+
+// reservationPayloads splits the group reservation saga payload into room reservation saga payloads
+func reservationPayloads(payload jsonmap.JSONMap) []jsonmap.JSONMap {
+	items, _ := payload["reservations"].([]interface{})
+
+	payloads := make([]jsonmap.JSONMap, 0, len(items))
+	for _, item := range items {
+		switch p := item.(type) {
+		case jsonmap.JSONMap:
+			payloads = append(payloads, p)
+		case map[string]interface{}:
+			payloads = append(payloads, p)
+		}
+	}
+	return payloads
+}
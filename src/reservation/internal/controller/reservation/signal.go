@@ -0,0 +1,64 @@
+package reservation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"go.example/saga/pkg/saga"
+	"go.example/saga/pkg/store/postgres"
+	repo "go.example/saga/reservation/internal/repository"
+	"go.example/saga/reservation/pkg/model"
+	"log"
+	"time"
+)
+
+// approvalSignal the signal sent by a manager to approve/deny a high value reservation
+const approvalSignal = "approval"
+
+// PostSignal delivers an external signal (e.g. manager approval) to the saga awaiting it,
+// the reservation status is updated if the saga reached a final status
+func (c *Controller) PostSignal(ctx context.Context, sagaID string, name string, cmd model.SignalCmd) (*saga.SagaState, error) {
+	if _, err := uuid.Parse(sagaID); err != nil {
+		return nil, repo.ErrNotFound
+	}
+
+	return postgres.Transact(ctx, c.store, postgres.SerializableTx, func(tx *sql.Tx) (*saga.SagaState, error) {
+		signal := saga.Signal{Name: name, Approved: cmd.Approved, Data: cmd.Data}
+		state, err := c.orchestrator.Signal(ctx, tx, sagaID, signal)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repo.ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Saga %s received signal %s approved %t", sagaID, name, cmd.Approved)
+		return state, nil
+	})
+}
+
+// StartDeadlineWatcher periodically aborts the sagas which did not receive the awaited signal in time
+func (c *Controller) StartDeadlineWatcher(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("saga deadline interval must be positive")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// the reservations of the aborted sagas are updated within the TX (Definition.Ended)
+		if _, err := postgres.Transact(ctx, c.store, postgres.SerializableTx, func(tx *sql.Tx) ([]saga.SagaState, error) {
+			return c.orchestrator.ExpireDeadlines(ctx, tx, time.Now())
+		}); err != nil {
+			log.Printf("Failed to expire saga deadlines: %v", err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"go.example/saga/pkg/saga"
 	"go.example/saga/reservation/internal/controller/reservation"
 	"go.example/saga/reservation/internal/repository"
 	"go.example/saga/reservation/pkg/model"
@@ -32,14 +33,23 @@ func New(ctrl *reservation.Controller) *httprouter.Router {
 	router := httprouter.New()
 	router.POST("/api/v1/reservations", h.Create)
 	router.GET("/api/v1/reservations/:id", h.Read)
+	router.POST("/api/v1/group-reservations", h.CreateGroup)
+	router.GET("/api/v1/group-reservations/:id", h.ReadGroup)
+	router.POST("/api/v1/sagas/:id/signals/:name", h.Signal)
+	router.GET("/api/v1/admin/saga-stats", h.Stats)
 
 	return router
 }
 
-// Create POST new reservation
+// Create POST new reservation, or plan it with ?dryRun=true
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 
+	if r.URL.Query().Get("dryRun") == "true" {
+		h.dryRun(w, r)
+		return
+	}
+
 	var cmd model.ReservationCmd
 	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
 		http.Error(w, "Malformed JSON", http.StatusBadRequest)
@@ -63,6 +73,26 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request, ps httprouter.P
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// dryRun returns the plan of the reservation saga for the scripted participant replies, nothing is persisted
+func (h *Handler) dryRun(w http.ResponseWriter, r *http.Request) {
+	var cmd model.DryRunReservationCmd
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		http.Error(w, "Malformed JSON", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := h.ctrl.PlanReservation(r.Context(), cmd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+}
+
 // Read
 func (h *Handler) Read(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	re, err := h.ctrl.GetReservation(r.Context(), ps.ByName("id"))
@@ -72,9 +102,103 @@ func (h *Handler) Read(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		http.Error(w, "Reservation not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
 
 	if err := json.NewEncoder(w).Encode(re); err != nil {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 }
+
+// CreateGroup POST new group reservation
+func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var cmd model.GroupReservationCmd
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		http.Error(w, "Malformed JSON", http.StatusBadRequest)
+		return
+	}
+
+	s, err := h.ctrl.PostGroupReservation(r.Context(), cmd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	locationURL := &url.URL{
+		Scheme: "http", // FIXME
+		Host:   r.Host,
+		Path:   fmt.Sprintf("%s/%s", r.URL.Path, s.ID),
+	}
+
+	w.Header().Set("Location", locationURL.String())
+	w.Header().Set("Retry-After", "0.5") // sec
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ReadGroup
+func (h *Handler) ReadGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	g, err := h.ctrl.GetGroupReservation(r.Context(), ps.ByName("id"))
+
+	w.Header().Set("Content-Type", "application/json")
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Group reservation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(g); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Signal POST an external signal (e.g. manager approval) to the saga awaiting it
+func (h *Handler) Signal(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var cmd model.SignalCmd
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		http.Error(w, "Malformed JSON", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.ctrl.PostSignal(r.Context(), ps.ByName("id"), ps.ByName("name"), cmd)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Saga not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, saga.ErrUnexpectedSignal) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Stats GET the sagas outcome and duration statistics
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats, err := h.ctrl.GetSagaStats(r.Context())
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+}
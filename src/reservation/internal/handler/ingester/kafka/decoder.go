@@ -0,0 +1,24 @@
+package kafka
+
+import (
+	"encoding/json"
+	"go.example/saga/pkg/messaging"
+	"go.example/saga/reservation/pkg/model"
+)
+
+// Decode decodes the participant event of the received message
+func Decode[T model.Payload](m messaging.Message) (model.Event[T], error) {
+	var payload T
+	if err := json.Unmarshal(m.Value, &payload); err != nil {
+		return model.Event[T]{}, err
+	}
+	return model.Event[T]{
+		GroupID:   m.Group,
+		EventID:   m.EventID(),
+		EventType: m.EventType(),
+		MsgID:     m.Key,
+		Timestamp: m.Timestamp,
+		Payload:   payload,
+		Headers:   m.Metadata(),
+	}, nil
+}
@@ -0,0 +1,37 @@
+// Package app wires the reservation service, run by the service main or within a single process with the other services
+package app
+
+import (
+	"go.example/saga/pkg/messaging"
+	store "go.example/saga/pkg/store/postgres"
+	"go.example/saga/reservation/internal/controller/reservation"
+	"go.example/saga/reservation/internal/handler/ingester/kafka"
+	"go.example/saga/reservation/internal/repository/postgres"
+	"go.example/saga/reservation/pkg/model"
+)
+
+// Config the reservation sagas settings
+type Config = reservation.Config
+
+// Topic a consumed topic and the consumer group consuming it
+type Topic struct {
+	GroupID    string
+	InboxTopic string
+}
+
+// Service the reservation service controller and its ingesters
+type Service struct {
+	Controller  *reservation.Controller
+	RoomBooking *messaging.Ingester[model.Event[model.BookingEventPayload]]
+	Payment     *messaging.Ingester[model.Event[model.PaymentEventPayload]]
+}
+
+// New wires the reservation service, the events are consumed through the subscriber (e.g. kafka or the in-memory bus)
+func New(cfg Config, st *store.Store, subscriber messaging.Subscriber, roomBooking Topic, payment Topic) *Service {
+	roomBookIngester := messaging.NewIngester(subscriber, roomBooking.GroupID, roomBooking.InboxTopic, kafka.Decode[model.BookingEventPayload])
+	paymentIngester := messaging.NewIngester(subscriber, payment.GroupID, payment.InboxTopic, kafka.Decode[model.PaymentEventPayload])
+
+	ctrl := reservation.New(cfg, st, store.NewEventLogs(), postgres.New(), store.NewSagaRepository(), store.NewOutboxPublisher(),
+		store.NewSagaLocks(), store.NewSagaSlots(), roomBookIngester, paymentIngester)
+	return &Service{Controller: ctrl, RoomBooking: roomBookIngester, Payment: paymentIngester}
+}
@@ -58,6 +58,21 @@ type ReservationCmd struct {
 	CreditCardNO string `json:"creditCardNo"`
 }
 
+// DryRunReservationCmd plans the reservation saga against the scripted participant replies,
+// e.g. {"room-booking": "SUCCEEDED", "payment": "FAILED"}, see saga.Script
+type DryRunReservationCmd struct {
+	ReservationCmd
+	Responses map[string]string `json:"responses"`
+}
+
+// ReservationPlan the outcome of a reservation dry run, nothing is persisted nor published: the reservation,
+// its saga (none in choreography mode) and the outbox events the reservation service would publish
+type ReservationPlan struct {
+	Reservation *ReservationView       `json:"reservation"`
+	Saga        *saga.Plan             `json:"saga,omitempty"`
+	Events      []postgres.OutboxEvent `json:"events"`
+}
+
 type ReservationView struct {
 	ID      uuid.UUID         `json:"reservationId"`
 	HotelID int64             `json:"hotelId"`
@@ -66,6 +81,30 @@ type ReservationView struct {
 	Status  ReservationStatus `json:"status"`
 }
 
+// GroupReservationCmd requests one reservation per room, all of them succeed or fail together
+type GroupReservationCmd struct {
+	HotelID      int64   `json:"hotelId"`
+	RoomIDs      []int64 `json:"roomIds"`
+	StartDate    string  `json:"startDate"`
+	EndDate      string  `json:"endDate"`
+	GuestID      int64   `json:"guestId"`
+	PaymentDue   int64   `json:"paymentDue"`
+	CreditCardNO string  `json:"creditCardNo"`
+}
+
+// GroupReservationView defines the group reservation status and its room reservations
+type GroupReservationView struct {
+	ID           uuid.UUID         `json:"groupReservationId"`
+	Status       saga.SagaStatus   `json:"status"`
+	Reservations []ReservationView `json:"reservations"`
+}
+
+// SignalCmd external signal sent to a saga awaiting it, e.g. a manager approval
+type SignalCmd struct {
+	Approved bool            `json:"approved"`
+	Data     jsonmap.JSONMap `json:"data"`
+}
+
 func (r *Reservation) ToJSONMap() jsonmap.JSONMap {
 	return map[string]interface{}{
 		"reservationId": r.ID,
@@ -81,10 +120,13 @@ func (r *Reservation) ToJSONMap() jsonmap.JSONMap {
 }
 
 type Event[T Payload] struct {
+	GroupID   string
 	EventID   string
+	EventType string
 	MsgID     string
 	Timestamp time.Time
 	Payload   T
+	Headers   postgres.Headers
 }
 
 type Payload interface {
@@ -109,13 +151,17 @@ const (
 	BookingStatusBooked    = "BOOKED"
 	BookingStatusRejected  = "REJECTED"
 	BookingStatusCancelled = "CANCELLED"
+	BookingStatusHeld      = "HELD"
+	BookingStatusConfirmed = "CONFIRMED"
 )
 
 // SagaStepStatus defines the mapping - BookingStatus to SagaStepStatus
 func (r BookingEventPayload) SagaStepStatus() saga.SagaStepStatus {
 	switch r.Status {
-	case BookingStatusBooked:
+	case BookingStatusBooked, BookingStatusHeld:
 		return saga.SagaStepStatusSucceeded
+	case BookingStatusConfirmed:
+		return saga.SagaStepStatusConfirmed
 	case BookingStatusRejected:
 		return saga.SagaStepStatusFailed
 	case BookingStatusCancelled:
@@ -137,17 +183,21 @@ type (
 
 // PaymentStatus type.
 const (
-	PaymentStatusRequested = "REQUESTED"
-	PaymentStatusCancelled = "CANCELLED"
-	PaymentStatusFailed    = "FAILED"
-	PaymentStatusCompleted = "COMPLETED"
+	PaymentStatusRequested  = "REQUESTED"
+	PaymentStatusCancelled  = "CANCELLED"
+	PaymentStatusFailed     = "FAILED"
+	PaymentStatusCompleted  = "COMPLETED"
+	PaymentStatusAuthorized = "AUTHORIZED"
+	PaymentStatusCaptured   = "CAPTURED"
 )
 
 // SagaStepStatus defines the mapping - PaymentStatus to SagaStepStatus
 func (p PaymentEventPayload) SagaStepStatus() saga.SagaStepStatus {
 	switch p.Status {
-	case PaymentStatusRequested, PaymentStatusCompleted:
+	case PaymentStatusRequested, PaymentStatusCompleted, PaymentStatusAuthorized:
 		return saga.SagaStepStatusSucceeded
+	case PaymentStatusCaptured:
+		return saga.SagaStepStatusConfirmed
 	case PaymentStatusFailed:
 		return saga.SagaStepStatusFailed
 	case PaymentStatusCancelled:
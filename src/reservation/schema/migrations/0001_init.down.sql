@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS outboxevent;
+DROP TABLE IF EXISTS eventlog;
+DROP TABLE IF EXISTS sagaslot;
+DROP TABLE IF EXISTS sagalock;
+DROP TABLE IF EXISTS sagastate_archive;
+DROP TABLE IF EXISTS sagastate;
+DROP TABLE IF EXISTS reservation;
@@ -0,0 +1,104 @@
+CREATE TABLE IF NOT EXISTS reservation
+(
+    id             UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    timestamp      TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    hotel_id       BIGINT      NOT NULL,
+    room_id        INT         NOT NULL,
+    start_date     DATE        NOT NULL,
+    end_date       DATE        NOT NULL,
+    status         VARCHAR(20) NOT NULL,
+    guest_id       BIGINT      NOT NULL,
+    payment_due    BIGINT      NOT NULL,
+    credit_card_no VARCHAR(16) NOT NULL
+);
+
+-- Infrastructure tables
+CREATE TABLE IF NOT EXISTS sagastate
+(
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    parent_id    UUID REFERENCES sagastate (id),
+    version      int8         NOT NULL,
+    type         VARCHAR(100) NOT NULL,
+    payload      JSONB        NOT NULL,
+    current_step VARCHAR(100),
+    step_status  JSONB,
+    saga_status  VARCHAR(100),
+    deadline     TIMESTAMP,
+    step_times   JSONB     NOT NULL DEFAULT '{}',
+    started_on   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ended_on     TIMESTAMP,
+    updated_on   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sagastate_parent_id_idx ON sagastate (parent_id);
+CREATE INDEX IF NOT EXISTS sagastate_deadline_idx ON sagastate (deadline) WHERE deadline IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS sagastate_retention_idx ON sagastate (saga_status, updated_on) WHERE parent_id IS NULL;
+
+-- terminal sagas moved by the retention job
+CREATE TABLE IF NOT EXISTS sagastate_archive
+(
+    id           UUID PRIMARY KEY,
+    parent_id    UUID,
+    version      int8         NOT NULL,
+    type         VARCHAR(100) NOT NULL,
+    payload      JSONB        NOT NULL,
+    current_step VARCHAR(100),
+    step_status  JSONB,
+    saga_status  VARCHAR(100),
+    step_times   JSONB,
+    started_on   TIMESTAMP,
+    ended_on     TIMESTAMP,
+    updated_on   TIMESTAMP    NOT NULL,
+    archived_on  TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- saga semantic locks, one HELD row per lock name, the WAITING sagas are granted the lock in FIFO order
+CREATE TABLE IF NOT EXISTS sagalock
+(
+    name         VARCHAR(255) NOT NULL,
+    saga_id      UUID         NOT NULL,
+    status       VARCHAR(20)  NOT NULL,
+    requested_on TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (name, saga_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS sagalock_held_idx ON sagalock (name) WHERE status = 'HELD';
+CREATE INDEX IF NOT EXISTS sagalock_saga_id_idx ON sagalock (saga_id);
+
+-- saga concurrency slots, one row per limit applying to the saga, the PENDING sagas begin in FIFO order
+CREATE TABLE IF NOT EXISTS sagaslot
+(
+    name         VARCHAR(255) NOT NULL,
+    saga_id      UUID         NOT NULL,
+    max_active   INT          NOT NULL,
+    status       VARCHAR(20)  NOT NULL,
+    requested_on TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (name, saga_id)
+);
+
+CREATE INDEX IF NOT EXISTS sagaslot_name_status_idx ON sagaslot (name, status);
+CREATE INDEX IF NOT EXISTS sagaslot_saga_id_idx ON sagaslot (saga_id);
+
+CREATE TABLE IF NOT EXISTS eventlog
+(
+    event_id  UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    issued_on TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS eventlog_issued_on_idx ON eventlog (issued_on);
+
+CREATE TABLE IF NOT EXISTS outboxevent
+(
+    id            UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
+    timestamp     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    aggregatetype VARCHAR(100) NOT NULL,
+    aggregateid   VARCHAR(100) NOT NULL,
+    type          VARCHAR(100) NOT NULL,
+    payload       JSONB        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS outboxevent_timestamp_idx ON outboxevent (timestamp);
+
+ALTER TABLE outboxevent
+    REPLICA IDENTITY FULL;
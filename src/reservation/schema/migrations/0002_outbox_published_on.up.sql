@@ -0,0 +1,5 @@
+-- outbox events published by the Go relay (instead of debezium) are marked published
+ALTER TABLE outboxevent
+    ADD COLUMN IF NOT EXISTS published_on TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS outboxevent_pending_idx ON outboxevent (timestamp) WHERE published_on IS NULL;
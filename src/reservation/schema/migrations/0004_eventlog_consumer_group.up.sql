@@ -0,0 +1,9 @@
+-- the consumed events are deduplicated per consumer group
+ALTER TABLE eventlog
+    ADD COLUMN IF NOT EXISTS consumer_group VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE eventlog
+    DROP CONSTRAINT IF EXISTS eventlog_pkey;
+
+ALTER TABLE eventlog
+    ADD CONSTRAINT eventlog_pkey PRIMARY KEY (consumer_group, event_id);
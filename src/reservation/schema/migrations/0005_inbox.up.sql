@@ -0,0 +1,23 @@
+-- raw Kafka messages received, processed asynchronously by the inbox workers and kept when failed
+CREATE TABLE IF NOT EXISTS inbox
+(
+    id              BIGSERIAL PRIMARY KEY,
+    consumer_group  VARCHAR(255) NOT NULL,
+    event_id        UUID         NOT NULL,
+    event_type      VARCHAR(255) NOT NULL DEFAULT '',
+    topic           VARCHAR(255) NOT NULL,
+    kafka_partition INT          NOT NULL,
+    kafka_offset    BIGINT       NOT NULL,
+    msg_key         VARCHAR(255) NOT NULL DEFAULT '',
+    timestamp       TIMESTAMP    NOT NULL,
+    headers         JSONB        NOT NULL DEFAULT '{}',
+    payload         BYTEA        NOT NULL,
+    status          VARCHAR(16)  NOT NULL DEFAULT 'PENDING',
+    attempts        INT          NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_on TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    received_on     TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (consumer_group, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS inbox_pending_idx ON inbox (consumer_group, msg_key, id) WHERE status = 'PENDING';